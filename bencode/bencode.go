@@ -3,6 +3,7 @@ package bencode
 import (
 	"bytes"
 	"crypto/sha1"
+	"errors"
 	"fmt"
 	"regexp"
 	"strconv"
@@ -25,6 +26,9 @@ const (
 	torrentDictOffsetsKey = "byte_offsets"
 )
 
+// ErrInvalidBencode is returned when the data is not valid bencode or does not have the expected torrent structure
+var ErrInvalidBencode = errors.New("invalid bencode data")
+
 // TorrentInfo contains all relevant information extracted from a bencode file
 type TorrentInfo struct {
 	Name               string
@@ -34,10 +38,10 @@ type TorrentInfo struct {
 	InfoHashURLEncoded string
 }
 
-//TrackerInfo contains http urls from the tracker
+//TrackerInfo contains the tracker urls grouped by the announce-list tiers (BEP 12)
 type TrackerInfo struct {
-	Main string
-	Urls []string
+	Main  string
+	Tiers [][]string
 }
 
 type torrentDict struct {
@@ -48,7 +52,7 @@ type torrentDict struct {
 func TorrentDictParse(dat []byte) (torrent *TorrentInfo, err error) {
 	defer func() {
 		if e := recover(); e != nil {
-			err = e.(error)
+			torrent, err = nil, recoveredError(e)
 		}
 	}()
 
@@ -93,31 +97,33 @@ func (t *torrentDict) extractTotalSize() int {
 }
 
 func (t *torrentDict) extractTrackerInfo() *TrackerInfo {
-	uniqueUrls := make(map[string]int)
-	currentCount := 0
-	if main, ok := t.resultMap[mainAnnounceKey]; ok {
-		if _, found := uniqueUrls[main.(string)]; !found {
-			uniqueUrls[main.(string)] = currentCount
-			currentCount++
-		}
-	}
+	var trackerInfo TrackerInfo
+	uniqueUrls := make(map[string]bool)
 	if list, ok := t.resultMap[announceListKey]; ok {
 		for _, innerList := range list.([]interface{}) {
+			var tier []string
 			for _, item := range innerList.([]interface{}) {
-				if _, found := uniqueUrls[item.(string)]; !found {
-					uniqueUrls[item.(string)] = currentCount
-					currentCount++
+				if !uniqueUrls[item.(string)] {
+					uniqueUrls[item.(string)] = true
+					tier = append(tier, item.(string))
 				}
 			}
+			if len(tier) > 0 {
+				trackerInfo.Tiers = append(trackerInfo.Tiers, tier)
+			}
 		}
+	}
 
+	main, hasMain := t.resultMap[mainAnnounceKey].(string)
+	// BEP 12: the announce key is only used when there is no announce-list
+	if len(trackerInfo.Tiers) == 0 && hasMain {
+		trackerInfo.Tiers = [][]string{{main}}
 	}
-	trackerInfo := TrackerInfo{Urls: make([]string, len(uniqueUrls))}
-	for key, value := range uniqueUrls {
-		trackerInfo.Urls[value] = key
+	if hasMain {
+		trackerInfo.Main = main
+	} else if len(trackerInfo.Tiers) > 0 {
+		trackerInfo.Main = trackerInfo.Tiers[0][0]
 	}
-
-	trackerInfo.Main = trackerInfo.Urls[0]
 	return &trackerInfo
 }
 
@@ -125,7 +131,7 @@ func (t *torrentDict) extractTrackerInfo() *TrackerInfo {
 func Decode(data []byte) (dataMap map[string]interface{}, err error) {
 	defer func() {
 		if e := recover(); e != nil {
-			err = e.(error)
+			dataMap, err = nil, recoveredError(e)
 		}
 	}()
 
@@ -133,6 +139,14 @@ func Decode(data []byte) (dataMap map[string]interface{}, err error) {
 	return result.(map[string]interface{}), err
 }
 
+// recoveredError turns any panic value raised while walking malformed data into an ErrInvalidBencode
+func recoveredError(e interface{}) error {
+	if err, ok := e.(error); ok && errors.Is(err, ErrInvalidBencode) {
+		return err
+	}
+	return fmt.Errorf("%w: %v", ErrInvalidBencode, e)
+}
+
 func findParse(currentIdx int, data *[]byte) (result interface{}, nextIdx int) {
 	token := (*data)[currentIdx : currentIdx+1][0]
 	switch {
@@ -142,10 +156,10 @@ func findParse(currentIdx int, data *[]byte) (result interface{}, nextIdx int) {
 		return numberParse(currentIdx, data)
 	case token == listToken:
 		return listParse(currentIdx, data)
-	case token >= byte('0') || token <= byte('9'):
+	case token >= byte('0') && token <= byte('9'):
 		return stringParse(currentIdx, data)
 	default:
-		panic("Error decoding bencode")
+		panic(fmt.Errorf("%w: unexpected token %q at %v", ErrInvalidBencode, token, currentIdx))
 	}
 }
 
@@ -1,6 +1,7 @@
 package bencode
 
 import (
+	"errors"
 	"log"
 	"os"
 	"reflect"
@@ -112,3 +113,56 @@ func TestDecode(T *testing.T) {
 	}
 
 }
+
+func TestExtractTrackerInfo(T *testing.T) {
+	T.Run("announce-list tiers are kept and deduplicated", func(t *testing.T) {
+		input := []byte("d8:announce9:http://a113:announce-listll9:http://a19:http://a2el9:http://b19:http://a1eee")
+		dict, _ := mapParse(0, &input)
+		torrentMap := torrentDict{resultMap: dict}
+		got := torrentMap.extractTrackerInfo()
+		want := &TrackerInfo{Main: "http://a1", Tiers: [][]string{{"http://a1", "http://a2"}, {"http://b1"}}}
+		assertAreEqualDeep(t, got, want)
+	})
+	T.Run("announce is used when there is no announce-list", func(t *testing.T) {
+		input := []byte("d8:announce9:http://a1e")
+		dict, _ := mapParse(0, &input)
+		torrentMap := torrentDict{resultMap: dict}
+		got := torrentMap.extractTrackerInfo()
+		want := &TrackerInfo{Main: "http://a1", Tiers: [][]string{{"http://a1"}}}
+		assertAreEqualDeep(t, got, want)
+	})
+	T.Run("announce is ignored when announce-list is present", func(t *testing.T) {
+		input := []byte("d8:announce9:http://a113:announce-listll9:http://b1eee")
+		dict, _ := mapParse(0, &input)
+		torrentMap := torrentDict{resultMap: dict}
+		got := torrentMap.extractTrackerInfo()
+		want := &TrackerInfo{Main: "http://a1", Tiers: [][]string{{"http://b1"}}}
+		assertAreEqualDeep(t, got, want)
+	})
+}
+
+func TestInvalidData(T *testing.T) {
+	data := []struct {
+		name  string
+		input string
+	}{
+		{name: "empty", input: ""},
+		{name: "truncated dictionary", input: "d8:announce"},
+		{name: "unexpected token", input: "dx"},
+		{name: "not a torrent", input: "d3:fooi1ee"},
+	}
+	for _, td := range data {
+		T.Run(td.name, func(t *testing.T) {
+			_, err := TorrentDictParse([]byte(td.input))
+			if !errors.Is(err, ErrInvalidBencode) {
+				t.Errorf("got: %v want %v", err, ErrInvalidBencode)
+			}
+		})
+	}
+	T.Run("Decode non dictionary", func(t *testing.T) {
+		_, err := Decode([]byte("i1e"))
+		if !errors.Is(err, ErrInvalidBencode) {
+			t.Errorf("got: %v want %v", err, ErrInvalidBencode)
+		}
+	})
+}
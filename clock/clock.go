@@ -0,0 +1,52 @@
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock tells the time and waits, the announce loop goes through it so it can run on simulated time
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+// Real is the wall clock
+var Real Clock = realClock{}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// Simulated is a clock that never waits: After moves the time forward and fires right away
+type Simulated struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func NewSimulated(start time.Time) *Simulated {
+	return &Simulated{now: start}
+}
+
+func (s *Simulated) Now() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.now
+}
+
+func (s *Simulated) After(d time.Duration) <-chan time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if d > 0 {
+		s.now = s.now.Add(d)
+	}
+	c := make(chan time.Time, 1)
+	c <- s.now
+	return c
+}
@@ -0,0 +1,22 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSimulated(t *testing.T) {
+	start := time.Date(2021, 5, 10, 12, 0, 0, 0, time.UTC)
+	c := NewSimulated(start)
+
+	got := <-c.After(30 * time.Minute)
+	want := start.Add(30 * time.Minute)
+	if !got.Equal(want) || !c.Now().Equal(want) {
+		t.Errorf("got: %v want %v", got, want)
+	}
+
+	<-c.After(-time.Minute)
+	if !c.Now().Equal(want) {
+		t.Errorf("a negative wait should not go back in time, got: %v want %v", c.Now(), want)
+	}
+}
@@ -2,38 +2,97 @@ package emulation
 
 import (
 	"embed"
-	"encoding/json"
+	"errors"
+	"fmt"
 	generator2 "github.com/ap-pauloafonso/ratio-spoof/generator"
 	"io"
+	"io/fs"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 )
 
+// ErrUnknownClient is returned when there is no emulation profile for the client code
+var ErrUnknownClient = errors.New("unknown client code")
+
 type ClientInfo struct {
 	Name   string `json:"name"`
 	PeerID struct {
-		Generator string `json:"generator"`
-		Regex     string `json:"regex"`
+		Generator string        `json:"generator"`
+		Regex     string        `json:"regex"`
+		Refresh   RefreshPolicy `json:"refresh"`
 	} `json:"peerId"`
 	Key struct {
-		Generator string `json:"generator"`
-		Regex     string `json:"regex"`
+		Generator string        `json:"generator"`
+		Regex     string        `json:"regex"`
+		Refresh   RefreshPolicy `json:"refresh"`
 	} `json:"key"`
 	Rounding struct {
 		Generator string `json:"generator"`
 		Regex     string `json:"regex"`
 	} `json:"rounding"`
+	// NumWant is the amount of peers asked for on every announce but the stopped one, 0 is defaultNumWant
+	NumWant int               `json:"numwant"`
 	Query   string            `json:"query"`
 	Headers map[string]string `json:"headers"`
 }
 
-type KeyGenerator interface {
-	Key() string
+// defaultNumWant is the amount of peers libtorrent based clients ask for
+const defaultNumWant = 200
+
+func (c *ClientInfo) numWant() int {
+	if c.NumWant == 0 {
+		return defaultNumWant
+	}
+	return c.NumWant
+}
+
+// RefreshPolicy is how long a generated peer id or key lives
+type RefreshPolicy string
+
+const (
+	// RefreshProcess shares one value between every torrent of the process, a new one is generated on every start
+	RefreshProcess RefreshPolicy = "process"
+	// RefreshTorrent gives every torrent its own value, a new one is generated on every start
+	RefreshTorrent RefreshPolicy = "torrent"
+	// RefreshRestart gives every torrent a new value every time it sends the started event
+	RefreshRestart RefreshPolicy = "restart"
+	// RefreshPersisted gives every torrent its own value, saved with the torrent state and reused on the next start
+	RefreshPersisted RefreshPolicy = "persisted"
+)
+
+// refreshPolicies are the valid refresh policies, the empty one is persisted
+var refreshPolicies = map[RefreshPolicy]bool{"": true, RefreshProcess: true, RefreshTorrent: true, RefreshRestart: true, RefreshPersisted: true}
+
+func (p RefreshPolicy) orDefault() RefreshPolicy {
+	if p == "" {
+		return RefreshPersisted
+	}
+	return p
+}
+
+type KeyGenerator = generator2.KeyGenerator
+
+type PeerIdGenerator = generator2.PeerIdGenerator
+
+type RoundingGenerator = generator2.RoundingGenerator
+
+// peerIdGenerator is the peer id generator of the profile, the regex one when the profile only has a regex
+func (c *ClientInfo) peerIdGenerator() string {
+	if c.PeerID.Generator == "" {
+		return generator2.RegexPeerIdGeneratorName
+	}
+	return c.PeerID.Generator
 }
 
-type PeerIdGenerator interface {
-	PeerId() string
+func (c *ClientInfo) newPeerIdGenerator(rng *rand.Rand) (PeerIdGenerator, error) {
+	return generator2.NewPeerIdGenerator(c.peerIdGenerator(), c.PeerID.Regex, rng)
 }
-type RoundingGenerator interface {
-	Round(downloadCandidateNextAmount, uploadCandidateNextAmount, leftCandidateNextAmount, pieceSize int) (downloaded, uploaded, left int)
+
+func (c *ClientInfo) newKeyGenerator(rng *rand.Rand) (KeyGenerator, error) {
+	return generator2.NewKeyGenerator(c.Key.Generator, c.Key.Regex, rng)
 }
 
 type Emulation struct {
@@ -43,40 +102,169 @@ type Emulation struct {
 	Name    string
 	Headers map[string]string
 	RoundingGenerator
+	NumWant       int
+	PeerIdRefresh RefreshPolicy
+	KeyRefresh    RefreshPolicy
+
+	client *ClientInfo
+	// shared emulations announce with the identity of a session, it is never refreshed nor restored
+	shared bool
+}
+
+// Profiles finds the client profiles: the json files of Dir override the embedded profiles with the same code
+type Profiles struct {
+	Dir string
+}
+
+// NewEmulation builds the emulation of an embedded client profile, the peer id and key come from the random source
+func NewEmulation(code string, rng *rand.Rand) (*Emulation, error) {
+	return Profiles{}.NewEmulation(code, rng)
 }
 
-func NewEmulation(code string) (*Emulation, error) {
-	c, err := extractClient(code)
+// NewEmulation builds the emulation of the client profile, the peer id and key come from the random source
+func (p Profiles) NewEmulation(code string, rng *rand.Rand) (*Emulation, error) {
+	c, err := p.extractClient(code)
 	if err != nil {
 		return nil, err
 	}
 
-	peerG, err := generator2.NewRegexPeerIdGenerator(c.PeerID.Regex)
+	peerG, err := c.newPeerIdGenerator(rng)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("%v peer id generator: %w", code, err)
 	}
 
-	keyG, err := generator2.NewDefaultKeyGenerator()
+	keyG, err := c.newKeyGenerator(rng)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("%v key generator: %w", code, err)
 	}
 
-	roudingG, err := generator2.NewDefaultRoudingGenerator()
+	roudingG, err := generator2.NewRoundingGenerator(c.Rounding.Generator, c.Rounding.Regex, rng)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("%v rounding generator: %w", code, err)
 	}
 
 	return &Emulation{PeerIdGenerator: peerG, KeyGenerator: keyG, RoundingGenerator: roudingG,
-		Headers: c.Headers, Name: c.Name, Query: c.Query}, nil
+		Headers: c.Headers, Name: c.Name, Query: c.Query, NumWant: c.numWant(),
+		PeerIdRefresh: c.PeerID.Refresh.orDefault(), KeyRefresh: c.Key.Refresh.orDefault(), client: c}, nil
+
+}
+
+// Shared returns the emulation every torrent of a session announces with: the peer id and key of the session, whatever
+// their refresh policy
+func (e *Emulation) Shared() *Emulation {
+	shared := *e
+	shared.shared = true
+	return &shared
+}
 
+// Restart generates again the peer id and key refreshed on every restart, it is called when the torrent sends the started event
+func (e *Emulation) Restart(rng *rand.Rand) error {
+	return e.refresh(rng, func(policy RefreshPolicy) bool { return policy == RefreshRestart })
+}
+
+func (e *Emulation) refresh(rng *rand.Rand, refreshed func(policy RefreshPolicy) bool) error {
+	if e.client == nil || e.shared {
+		return nil
+	}
+	if refreshed(e.PeerIdRefresh) {
+		peerG, err := e.client.newPeerIdGenerator(rng)
+		if err != nil {
+			return fmt.Errorf("peer id generator: %w", err)
+		}
+		e.PeerIdGenerator = peerG
+	}
+	if refreshed(e.KeyRefresh) {
+		keyG, err := e.client.newKeyGenerator(rng)
+		if err != nil {
+			return fmt.Errorf("key generator: %w", err)
+		}
+		e.KeyGenerator = keyG
+	}
+	return nil
+}
+
+// Restore makes the emulation announce with the peer id and key generated by a previous run, only the persisted ones are reused
+// and an empty one is never restored
+func (e *Emulation) Restore(peerId, key string) {
+	if e.shared {
+		return
+	}
+	if e.PeerIdRefresh == RefreshPersisted && peerId != "" {
+		e.PeerIdGenerator = restoredIdentity(peerId)
+	}
+	if e.KeyRefresh == RefreshPersisted && key != "" {
+		e.KeyGenerator = restoredIdentity(key)
+	}
+}
+
+type restoredIdentity string
+
+func (r restoredIdentity) PeerId() string {
+	return string(r)
+}
+
+func (r restoredIdentity) Key() string {
+	return string(r)
 }
 
 //go:embed static
 var staticFiles embed.FS
 
+// Codes returns the code of every profile, embedded or in Dir, sorted
+func (p Profiles) Codes() ([]string, error) {
+	seen := make(map[string]bool)
+	var codes []string
+	add := func(entries []fs.DirEntry) {
+		for _, entry := range entries {
+			code, ok := strings.CutSuffix(entry.Name(), ".json")
+			if entry.IsDir() || !ok || seen[code] {
+				continue
+			}
+			seen[code] = true
+			codes = append(codes, code)
+		}
+	}
+
+	embedded, err := staticFiles.ReadDir("static")
+	if err != nil {
+		return nil, err
+	}
+	add(embedded)
+	if p.Dir != "" {
+		external, err := os.ReadDir(p.Dir)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+		add(external)
+	}
+	sort.Strings(codes)
+	return codes, nil
+}
+
+// extractClient reads the profile of Dir when there is one, the embedded one otherwise
+func (p Profiles) extractClient(code string) (*ClientInfo, error) {
+	if strings.ContainsAny(code, `/\`) || code == "." || code == ".." {
+		return nil, fmt.Errorf("%w: %v", ErrUnknownClient, code)
+	}
+	if p.Dir != "" {
+		path := filepath.Join(p.Dir, code+".json")
+		bytes, err := os.ReadFile(path)
+		if err == nil {
+			return parseClient(path, bytes)
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+	}
+	return extractClient(code)
+}
+
 func extractClient(code string) (*ClientInfo, error) {
 
 	f, err := staticFiles.Open("static/" + code + ".json")
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %v", ErrUnknownClient, code)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -87,9 +275,21 @@ func extractClient(code string) (*ClientInfo, error) {
 		return nil, err
 	}
 
-	var client ClientInfo
-
-	json.Unmarshal(bytes, &client)
+	return parseClient("embedded "+code+".json", bytes)
+}
 
-	return &client, nil
+// Validate checks every profile, embedded or in Dir, and returns one *InvalidProfileError per invalid profile
+func (p Profiles) Validate() ([]error, error) {
+	codes, err := p.Codes()
+	if err != nil {
+		return nil, err
+	}
+	var invalid []error
+	for _, code := range codes {
+		_, err := p.extractClient(code)
+		if err != nil {
+			invalid = append(invalid, err)
+		}
+	}
+	return invalid, nil
 }
@@ -1,7 +1,15 @@
 package emulation
 
 import (
+	"encoding/json"
+	"errors"
 	"io/fs"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
 	"strings"
 	"testing"
 )
@@ -10,8 +18,8 @@ func TestNewEmulation(t *testing.T) {
 	var counter int
 	fs.WalkDir(staticFiles, ".", func(path string, d fs.DirEntry, err error) error {
 		if counter > 1 {
-			code := strings.TrimRight(strings.TrimLeft(path, "static/"), ".json")
-			e, err := NewEmulation(code)
+			code := strings.TrimSuffix(strings.TrimPrefix(path, "static/"), ".json")
+			e, err := NewEmulation(code, rand.New(rand.NewSource(1)))
 			if err != nil {
 				t.Error("should not return error ")
 			}
@@ -27,9 +35,16 @@ func TestNewEmulation(t *testing.T) {
 			if key == "" {
 				t.Errorf("%s.json should be able to generate Key", code)
 			}
+			c, _ := extractClient(code)
+			if format := regexp.MustCompile("^(?:" + c.Key.Regex + ")$"); !format.MatchString(key) {
+				t.Errorf("%s.json key %v should match %v", code, key, format)
+			}
 			if d <= 0 || u <= 0 || l <= 0 {
 				t.Errorf("%s.json should be able to round candidates", code)
 			}
+			if e.NumWant <= 0 {
+				t.Errorf("%s.json should ask for peers, got numwant %v", code, e.NumWant)
+			}
 		}
 		counter++
 		return nil
@@ -40,7 +55,7 @@ func TestExtractClient(t *testing.T) {
 	var counter int
 	fs.WalkDir(staticFiles, ".", func(path string, d fs.DirEntry, err error) error {
 		if counter > 1 {
-			code := strings.TrimRight(strings.TrimLeft(path, "static/"), ".json")
+			code := strings.TrimSuffix(strings.TrimPrefix(path, "static/"), ".json")
 			c, e := extractClient(code)
 			if e != nil || err != nil {
 				t.Error("should not return error")
@@ -72,3 +87,226 @@ func TestExtractClient(t *testing.T) {
 	})
 
 }
+
+func TestNewEmulationUnknownClient(t *testing.T) {
+	_, err := NewEmulation("not-a-client", rand.New(rand.NewSource(1)))
+	if !errors.Is(err, ErrUnknownClient) {
+		t.Errorf("got: %v want %v", err, ErrUnknownClient)
+	}
+}
+
+func TestProfilesDir(t *testing.T) {
+	dir := t.TempDir()
+	for code, name := range map[string]string{"qbit-4.3.3": "overridden", "custom-1.0": "custom"} {
+		profile := testProfile(t, map[string]interface{}{"name": name, "headers": map[string]string{"User-Agent": name}})
+		if err := os.WriteFile(filepath.Join(dir, code+".json"), profile, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	profiles := Profiles{Dir: dir}
+
+	for code, want := range map[string]string{"qbit-4.3.3": "overridden", "custom-1.0": "custom", "qbit-4.0.3": "qBittorrent v4.0.3"} {
+		e, err := profiles.NewEmulation(code, rand.New(rand.NewSource(1)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e.Name != want {
+			t.Errorf("[%v]got: %v want %v", code, e.Name, want)
+		}
+	}
+
+	codes, err := profiles.Codes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	embedded, err := Profiles{}.Codes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := append([]string{"custom-1.0"}, embedded...)
+	sort.Strings(want)
+	if !reflect.DeepEqual(codes, want) {
+		t.Errorf("got: %v want %v", codes, want)
+	}
+
+	if _, err := profiles.NewEmulation("../qbit-4.3.3", rand.New(rand.NewSource(1))); !errors.Is(err, ErrUnknownClient) {
+		t.Errorf("got: %v want %v", err, ErrUnknownClient)
+	}
+}
+
+func TestProfilesDirMissing(t *testing.T) {
+	profiles := Profiles{Dir: filepath.Join(t.TempDir(), "missing")}
+	if _, err := profiles.NewEmulation("qbit-4.3.3", rand.New(rand.NewSource(1))); err != nil {
+		t.Errorf("a missing profiles directory should fall back to the embedded profiles, got %v", err)
+	}
+}
+
+func TestRefreshPolicies(t *testing.T) {
+	data := []struct {
+		policy    RefreshPolicy
+		restarted bool
+		restored  bool
+	}{
+		{policy: RefreshProcess},
+		{policy: RefreshTorrent},
+		{policy: RefreshRestart, restarted: true},
+		{policy: RefreshPersisted, restored: true},
+	}
+	for _, td := range data {
+		t.Run(string(td.policy), func(t *testing.T) {
+			dir := t.TempDir()
+			profile := testProfile(t, map[string]interface{}{"peerId.refresh": td.policy, "key.refresh": td.policy})
+			if err := os.WriteFile(filepath.Join(dir, "custom-1.0.json"), profile, 0o644); err != nil {
+				t.Fatal(err)
+			}
+			torrent, err := Profiles{Dir: dir}.NewEmulation("custom-1.0", rand.New(rand.NewSource(1)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			shared := torrent.Shared()
+
+			peerId, key := torrent.PeerId(), torrent.Key()
+			if err := torrent.Restart(rand.New(rand.NewSource(3))); err != nil {
+				t.Fatal(err)
+			}
+			if restarted := torrent.PeerId() != peerId && torrent.Key() != key; restarted != td.restarted {
+				t.Errorf("refreshed on restart got: %v want %v", restarted, td.restarted)
+			}
+
+			torrent.Restore("-XX0100-restoredpeer", "RESTORED")
+			if restored := torrent.PeerId() == "-XX0100-restoredpeer" && torrent.Key() == "RESTORED"; restored != td.restored {
+				t.Errorf("restored from the state got: %v want %v", restored, td.restored)
+			}
+
+			// the identity of a session stays whatever the policy
+			if err := shared.Restart(rand.New(rand.NewSource(3))); err != nil {
+				t.Fatal(err)
+			}
+			shared.Restore("-XX0100-restoredpeer", "RESTORED")
+			if shared.PeerId() != peerId || shared.Key() != key {
+				t.Errorf("shared got: %v %v want %v %v", shared.PeerId(), shared.Key(), peerId, key)
+			}
+		})
+	}
+}
+
+func TestParseClientProblems(t *testing.T) {
+	data := []struct {
+		name    string
+		profile []byte
+		want    []string
+	}{
+		{
+			name:    "valid profile",
+			profile: testProfile(t, map[string]interface{}{"query": testQuery + "{event}", "headers": map[string]string{}}),
+		},
+		{
+			name:    "invalid json",
+			profile: []byte(`{"name":`),
+			want:    []string{"unexpected end of JSON input"},
+		},
+		{
+			name: "every problem is reported",
+			profile: testProfile(t, map[string]interface{}{"name": nil, "nam": "c", "peerId.regex": "-XX0100-[a-z]{10}", "peerId.size": 20,
+				"key.generator": "fancyKey", "rounding.regex": "[0-9]+", "query": strings.Replace(testQuery, "{peerid}", "{peer_id}", 1)}),
+			want: []string{
+				"nam: unknown field",
+				"peerId.size: unknown field",
+				"name: required",
+				"query: missing the {peerid} placeholder",
+				"query: unknown placeholder {peer_id}",
+				`peerId.regex: generates "-XX0100-lhhghcmref" with 18 bytes, a peer id has 20`,
+				"key.generator: unknown generator fancyKey",
+				"rounding.regex: not supported by defaultRoudingGenerator",
+			},
+		},
+		{
+			name:    "generators are required and must be registered",
+			profile: testProfile(t, map[string]interface{}{"peerId.regex": nil, "peerId.generator": "fancyPeerId", "key.generator": nil, "key.regex": "[0-9]+"}),
+			want: []string{
+				"peerId.generator: unknown generator fancyPeerId",
+				"key.generator: required",
+			},
+		},
+		{
+			name:    "key regex does not match the generated keys",
+			profile: testProfile(t, map[string]interface{}{"key.generator": "lowerHexKeyGenerator", "key.regex": "[0-9A-F]{8}"}),
+			want:    []string{`key.regex: generates "9acb0442" which does not match`},
+		},
+		{
+			name:    "unknown refresh policies",
+			profile: testProfile(t, map[string]interface{}{"peerId.refresh": "never", "key.refresh": "always"}),
+			want:    []string{"peerId.refresh: unknown refresh policy never", "key.refresh: unknown refresh policy always"},
+		},
+		{
+			name:    "negative numwant",
+			profile: testProfile(t, map[string]interface{}{"numwant": -1}),
+			want:    []string{"numwant: must not be negative"},
+		},
+		{
+			name:    "peer id regex is required",
+			profile: testProfile(t, map[string]interface{}{"peerId.regex": nil}),
+			want:    []string{"peerId.regex: required"},
+		},
+		{
+			name:    "peer id regex does not compile",
+			profile: testProfile(t, map[string]interface{}{"peerId.regex": "-XX0100-[a-z{12}"}),
+			want:    []string{"peerId.regex: error parsing regexp: missing closing ]: `[a-z{12}`"},
+		},
+	}
+
+	for _, td := range data {
+		t.Run(td.name, func(t *testing.T) {
+			_, err := parseClient("test.json", td.profile)
+			var got []string
+			var invalid *InvalidProfileError
+			if errors.As(err, &invalid) {
+				for _, problem := range invalid.Problems {
+					got = append(got, problem.String())
+				}
+			}
+			if !reflect.DeepEqual(got, td.want) {
+				t.Errorf("\ngot : %q\nwant: %q", got, td.want)
+			}
+		})
+	}
+}
+
+// testQuery is the query of testProfile, it has every required placeholder
+const testQuery = "info_hash={infohash}&peer_id={peerid}&port={port}&uploaded={uploaded}&downloaded={downloaded}&left={left}"
+
+// testProfile is the json of a valid client profile, every override sets the field of its dotted path and nil removes it
+func testProfile(t *testing.T, overrides map[string]interface{}) []byte {
+	t.Helper()
+	profile := map[string]interface{}{
+		"name":     "c",
+		"peerId":   map[string]interface{}{"regex": "-XX0100-[a-z]{12}"},
+		"key":      map[string]interface{}{"generator": "defaultKeyGenerator"},
+		"rounding": map[string]interface{}{"generator": "defaultRoudingGenerator"},
+		"query":    testQuery,
+	}
+	for path, value := range overrides {
+		object := profile
+		field := path
+		if parent, child, ok := strings.Cut(path, "."); ok {
+			object, field = profile[parent].(map[string]interface{}), child
+		}
+		if value == nil {
+			delete(object, field)
+		} else {
+			object[field] = value
+		}
+	}
+	dat, err := json.Marshal(profile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dat
+}
+
+func TestValidateEmbeddedProfiles(t *testing.T) {
+	invalid, err := Profiles{}.Validate()
+	if err != nil || len(invalid) > 0 {
+		t.Errorf("embedded profiles should be valid, got %v %v", invalid, err)
+	}
+}
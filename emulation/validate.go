@@ -0,0 +1,221 @@
+package emulation
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	generator2 "github.com/ap-pauloafonso/ratio-spoof/generator"
+	"math/rand"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	peerIdLength       = 20
+	peerIdRegexSamples = 20
+	keyRegexSamples    = 20
+)
+
+// requiredPlaceholders must be in every query, a tracker can not take an announce without them
+var requiredPlaceholders = []string{"infohash", "peerid", "port", "uploaded", "downloaded", "left"}
+
+// knownPlaceholders are every placeholder replaced when announcing
+var knownPlaceholders = map[string]bool{"infohash": true, "peerid": true, "port": true, "uploaded": true, "downloaded": true,
+	"left": true, "key": true, "event": true, "numwant": true, "trackerid": true}
+
+// knownFields are the fields of a profile, the objects list their own fields
+var knownFields = map[string][]string{
+	"":         {"name", "peerId", "key", "rounding", "numwant", "query", "headers"},
+	"peerId":   {"generator", "regex", "refresh"},
+	"key":      {"generator", "regex", "refresh"},
+	"rounding": {"generator", "regex"},
+}
+
+var placeholderRegex = regexp.MustCompile(`{([^{}]*)}`)
+
+// ProfileProblem is one problem of a client profile, Field is its json path
+type ProfileProblem struct {
+	Field   string
+	Message string
+}
+
+func (p ProfileProblem) String() string {
+	if p.Field == "" {
+		return p.Message
+	}
+	return p.Field + ": " + p.Message
+}
+
+// InvalidProfileError lists every problem found in a client profile
+type InvalidProfileError struct {
+	Source   string
+	Problems []ProfileProblem
+}
+
+func (e *InvalidProfileError) Error() string {
+	problems := make([]string, len(e.Problems))
+	for i, problem := range e.Problems {
+		problems[i] = problem.String()
+	}
+	return fmt.Sprintf("invalid client profile %v: %v", e.Source, strings.Join(problems, "; "))
+}
+
+// parseClient decodes and validates the profile read from source, every problem is reported at once
+func parseClient(source string, data []byte) (*ClientInfo, error) {
+	var problems []ProfileProblem
+	var fields map[string]interface{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, &InvalidProfileError{Source: source, Problems: []ProfileProblem{{Message: err.Error()}}}
+	}
+	problems = append(problems, unknownFields(fields)...)
+
+	var client ClientInfo
+	if err := json.NewDecoder(bytes.NewReader(data)).Decode(&client); err != nil {
+		problems = append(problems, ProfileProblem{Message: err.Error()})
+	} else {
+		problems = append(problems, client.validate()...)
+	}
+
+	if len(problems) > 0 {
+		return nil, &InvalidProfileError{Source: source, Problems: problems}
+	}
+	return &client, nil
+}
+
+func unknownFields(fields map[string]interface{}) []ProfileProblem {
+	var problems []ProfileProblem
+	for parent, known := range knownFields {
+		object := fields
+		if parent != "" {
+			object, _ = fields[parent].(map[string]interface{})
+		}
+		for field := range object {
+			if !contains(known, field) {
+				path := field
+				if parent != "" {
+					path = parent + "." + field
+				}
+				problems = append(problems, ProfileProblem{Field: path, Message: "unknown field"})
+			}
+		}
+	}
+	sort.Slice(problems, func(i, j int) bool { return problems[i].Field < problems[j].Field })
+	return problems
+}
+
+func (c *ClientInfo) validate() []ProfileProblem {
+	var problems []ProfileProblem
+	problem := func(field, format string, args ...interface{}) {
+		problems = append(problems, ProfileProblem{Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if c.Name == "" {
+		problem("name", "required")
+	}
+
+	if c.Query == "" {
+		problem("query", "required")
+	} else {
+		for _, placeholder := range requiredPlaceholders {
+			if !strings.Contains(c.Query, "{"+placeholder+"}") {
+				problem("query", "missing the {%v} placeholder", placeholder)
+			}
+		}
+		for _, match := range placeholderRegex.FindAllStringSubmatch(c.Query, -1) {
+			if !knownPlaceholders[match[1]] {
+				problem("query", "unknown placeholder %v", match[0])
+			}
+		}
+	}
+
+	peerIdGenerator := c.peerIdGenerator()
+	problems = append(problems, generatorProblems("peerId", peerIdGenerator, checkPeerIdGenerator(peerIdGenerator, c.PeerID.Regex))...)
+
+	problems = append(problems, generatorProblems("key", c.Key.Generator, checkKeyGenerator(c.Key.Generator, c.Key.Regex))...)
+
+	if !refreshPolicies[c.PeerID.Refresh] {
+		problem("peerId.refresh", "unknown refresh policy %v", c.PeerID.Refresh)
+	}
+	if !refreshPolicies[c.Key.Refresh] {
+		problem("key.refresh", "unknown refresh policy %v", c.Key.Refresh)
+	}
+
+	if c.NumWant < 0 {
+		problem("numwant", "must not be negative")
+	}
+
+	_, err := generator2.NewRoundingGenerator(c.Rounding.Generator, c.Rounding.Regex, rand.New(rand.NewSource(1)))
+	problems = append(problems, generatorProblems("rounding", c.Rounding.Generator, err)...)
+	return problems
+}
+
+// checkPeerIdGenerator makes sure the generator builds and every peer id it generates has exactly 20 bytes
+func checkPeerIdGenerator(name, regex string) error {
+	if regex != "" {
+		if _, err := regexp.Compile(regex); err != nil {
+			return err
+		}
+	}
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < peerIdRegexSamples; i++ {
+		g, err := generator2.NewPeerIdGenerator(name, regex, rng)
+		if err != nil {
+			return err
+		}
+		if peerId := g.PeerId(); len(peerId) != peerIdLength {
+			return fmt.Errorf("generates %q with %v bytes, a peer id has %v", peerId, len(peerId), peerIdLength)
+		}
+	}
+	return nil
+}
+
+// checkKeyGenerator makes sure the generator builds and, when the profile has a key regex, every key it generates matches it
+func checkKeyGenerator(name, regex string) error {
+	var format *regexp.Regexp
+	if regex != "" {
+		var err error
+		if format, err = regexp.Compile("^(?:" + regex + ")$"); err != nil {
+			return err
+		}
+	}
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < keyRegexSamples; i++ {
+		g, err := generator2.NewKeyGenerator(name, regex, rng)
+		if err != nil {
+			return err
+		}
+		if format != nil && !format.MatchString(g.Key()) {
+			return fmt.Errorf("generates %q which does not match", g.Key())
+		}
+	}
+	return nil
+}
+
+// generatorProblems turns the error of building the generator selected by field into problems of its generator or regex
+func generatorProblems(field, name string, err error) []ProfileProblem {
+	switch {
+	case name == "":
+		return []ProfileProblem{{Field: field + ".generator", Message: "required"}}
+	case err == nil:
+		return nil
+	case errors.Is(err, generator2.ErrUnknownGenerator):
+		return []ProfileProblem{{Field: field + ".generator", Message: fmt.Sprintf("unknown generator %v", name)}}
+	case errors.Is(err, generator2.ErrRegexNotSupported):
+		return []ProfileProblem{{Field: field + ".regex", Message: fmt.Sprintf("not supported by %v", name)}}
+	case errors.Is(err, generator2.ErrRegexRequired):
+		return []ProfileProblem{{Field: field + ".regex", Message: "required"}}
+	default:
+		return []ProfileProblem{{Field: field + ".regex", Message: err.Error()}}
+	}
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -1,14 +1,18 @@
 package generator
 
 import (
-	"crypto/rand"
 	"encoding/hex"
+	"fmt"
+	"math/rand"
 	"strings"
 )
 
-func NewDefaultKeyGenerator() (*DefaultKeyGenerator, error) {
+// NewDefaultKeyGenerator generates 8 uppercase hex characters like libtorrent based clients
+func NewDefaultKeyGenerator(rng *rand.Rand) (*DefaultKeyGenerator, error) {
 	randomBytes := make([]byte, 4)
-	rand.Read(randomBytes)
+	if _, err := rng.Read(randomBytes); err != nil {
+		return nil, err
+	}
 	str := hex.EncodeToString(randomBytes)
 	result := strings.ToUpper(str)
 	return &DefaultKeyGenerator{generated: result}, nil
@@ -21,3 +25,21 @@ type DefaultKeyGenerator struct {
 func (d *DefaultKeyGenerator) Key() string {
 	return d.generated
 }
+
+// NewLowerHexKeyGenerator generates 8 lowercase hex characters like Transmission
+func NewLowerHexKeyGenerator(rng *rand.Rand) (*HexKeyGenerator, error) {
+	return &HexKeyGenerator{generated: fmt.Sprintf("%08x", rng.Uint32())}, nil
+}
+
+// NewVariableHexKeyGenerator generates up to 8 uppercase hex characters without leading zeros like µTorrent
+func NewVariableHexKeyGenerator(rng *rand.Rand) (*HexKeyGenerator, error) {
+	return &HexKeyGenerator{generated: fmt.Sprintf("%X", rng.Uint32())}, nil
+}
+
+type HexKeyGenerator struct {
+	generated string
+}
+
+func (h *HexKeyGenerator) Key() string {
+	return h.generated
+}
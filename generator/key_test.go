@@ -1,10 +1,14 @@
 package generator
 
-import "testing"
+import (
+	"math/rand"
+	"regexp"
+	"testing"
+)
 
 func TestDeaultKeyGenerator(t *testing.T) {
 	t.Run("Key has 8 length", func(t *testing.T) {
-		obj, _ := NewDefaultKeyGenerator()
+		obj, _ := NewDefaultKeyGenerator(rand.New(rand.NewSource(1)))
 		key := obj.Key()
 		if len(key) != 8 {
 			t.Error("Keys must have length of 8")
@@ -12,3 +16,36 @@ func TestDeaultKeyGenerator(t *testing.T) {
 
 	})
 }
+
+func TestDefaultKeyGeneratorSeed(t *testing.T) {
+	first, _ := NewDefaultKeyGenerator(rand.New(rand.NewSource(7)))
+	second, _ := NewDefaultKeyGenerator(rand.New(rand.NewSource(7)))
+	if first.Key() != second.Key() {
+		t.Errorf("got %v want %v", second.Key(), first.Key())
+	}
+}
+
+func TestHexKeyGenerators(t *testing.T) {
+	data := []struct {
+		name         string
+		newGenerator func(rng *rand.Rand) (*HexKeyGenerator, error)
+		want         *regexp.Regexp
+	}{
+		{name: "lower hex", newGenerator: NewLowerHexKeyGenerator, want: regexp.MustCompile(`^[0-9a-f]{8}$`)},
+		{name: "variable hex", newGenerator: NewVariableHexKeyGenerator, want: regexp.MustCompile(`^(0|[1-9A-F][0-9A-F]{0,7})$`)},
+	}
+	for _, td := range data {
+		t.Run(td.name, func(t *testing.T) {
+			rng := rand.New(rand.NewSource(1))
+			for i := 0; i < 100; i++ {
+				g, err := td.newGenerator(rng)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !td.want.MatchString(g.Key()) {
+					t.Errorf("got: %v want %v", g.Key(), td.want)
+				}
+			}
+		})
+	}
+}
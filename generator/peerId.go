@@ -1,6 +1,8 @@
 package generator
 
 import (
+	"math/rand"
+
 	regen "github.com/zach-klippenstein/goregen"
 )
 
@@ -8,12 +10,12 @@ type RegexPeerIdGenerator struct {
 	generated string
 }
 
-func NewRegexPeerIdGenerator(pattern string) (*RegexPeerIdGenerator, error) {
-	result, err := regen.Generate(pattern)
+func NewRegexPeerIdGenerator(pattern string, rng *rand.Rand) (*RegexPeerIdGenerator, error) {
+	g, err := regen.NewGenerator(pattern, &regen.GeneratorArgs{RngSource: rng})
 	if err != nil {
 		return nil, err
 	}
-	return &RegexPeerIdGenerator{generated: result}, nil
+	return &RegexPeerIdGenerator{generated: g.Generate()}, nil
 }
 
 func (d *RegexPeerIdGenerator) PeerId() string {
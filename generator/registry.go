@@ -0,0 +1,141 @@
+package generator
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+)
+
+var (
+	// ErrUnknownGenerator is returned when there is no generator with the given name
+	ErrUnknownGenerator = errors.New("unknown generator")
+	// ErrRegexNotSupported is returned when a regex is given to a generator that does not take one
+	ErrRegexNotSupported = errors.New("regex not supported by the generator")
+	// ErrRegexRequired is returned when a generator driven by a regex does not get one
+	ErrRegexRequired = errors.New("regex required by the generator")
+)
+
+const (
+	DefaultKeyGeneratorName      = "defaultKeyGenerator"
+	LowerHexKeyGeneratorName     = "lowerHexKeyGenerator"
+	VariableHexKeyGeneratorName  = "variableHexKeyGenerator"
+	RegexPeerIdGeneratorName     = "regexPeerIdGenerator"
+	DefaultRoundingGeneratorName = "defaultRoudingGenerator"
+	BlockRoundingGeneratorName   = "blockRoundingGenerator"
+	PieceRoundingGeneratorName   = "pieceRoundingGenerator"
+	NoRoundingGeneratorName      = "noRoundingGenerator"
+)
+
+type KeyGenerator interface {
+	Key() string
+}
+
+type PeerIdGenerator interface {
+	PeerId() string
+}
+
+type RoundingGenerator interface {
+	Round(downloadCandidateNextAmount, uploadCandidateNextAmount, leftCandidateNextAmount, pieceSize int) (downloaded, uploaded, left int)
+}
+
+// keyGenerators, peerIdGenerators and roundingGenerators build the generators a client profile selects by name, with the profile regex.
+// The key regex does not drive the generator, it is the format the generated keys are checked against
+var (
+	keyGenerators = map[string]func(regex string, rng *rand.Rand) (KeyGenerator, error){
+		DefaultKeyGeneratorName: func(regex string, rng *rand.Rand) (KeyGenerator, error) {
+			return NewDefaultKeyGenerator(rng)
+		},
+		LowerHexKeyGeneratorName: func(regex string, rng *rand.Rand) (KeyGenerator, error) {
+			return NewLowerHexKeyGenerator(rng)
+		},
+		VariableHexKeyGeneratorName: func(regex string, rng *rand.Rand) (KeyGenerator, error) {
+			return NewVariableHexKeyGenerator(rng)
+		},
+	}
+	peerIdGenerators = map[string]func(regex string, rng *rand.Rand) (PeerIdGenerator, error){
+		RegexPeerIdGeneratorName: func(regex string, rng *rand.Rand) (PeerIdGenerator, error) {
+			if regex == "" {
+				return nil, ErrRegexRequired
+			}
+			return NewRegexPeerIdGenerator(regex, rng)
+		},
+	}
+	roundingGenerators = map[string]func(regex string, rng *rand.Rand) (RoundingGenerator, error){
+		DefaultRoundingGeneratorName: func(regex string, rng *rand.Rand) (RoundingGenerator, error) {
+			if regex != "" {
+				return nil, ErrRegexNotSupported
+			}
+			return NewDefaultRoudingGenerator()
+		},
+		BlockRoundingGeneratorName: func(regex string, rng *rand.Rand) (RoundingGenerator, error) {
+			if regex != "" {
+				return nil, ErrRegexNotSupported
+			}
+			return NewBlockRoundingGenerator()
+		},
+		PieceRoundingGeneratorName: func(regex string, rng *rand.Rand) (RoundingGenerator, error) {
+			if regex != "" {
+				return nil, ErrRegexNotSupported
+			}
+			return NewPieceRoundingGenerator()
+		},
+		NoRoundingGeneratorName: func(regex string, rng *rand.Rand) (RoundingGenerator, error) {
+			if regex != "" {
+				return nil, ErrRegexNotSupported
+			}
+			return NewNoRoundingGenerator()
+		},
+	}
+)
+
+// NewKeyGenerator builds the key generator registered with the given name
+func NewKeyGenerator(name, regex string, rng *rand.Rand) (KeyGenerator, error) {
+	newGenerator, ok := keyGenerators[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %v", ErrUnknownGenerator, name)
+	}
+	return newGenerator(regex, rng)
+}
+
+// NewPeerIdGenerator builds the peer id generator registered with the given name
+func NewPeerIdGenerator(name, regex string, rng *rand.Rand) (PeerIdGenerator, error) {
+	newGenerator, ok := peerIdGenerators[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %v", ErrUnknownGenerator, name)
+	}
+	return newGenerator(regex, rng)
+}
+
+// NewRoundingGenerator builds the rounding generator registered with the given name
+func NewRoundingGenerator(name, regex string, rng *rand.Rand) (RoundingGenerator, error) {
+	newGenerator, ok := roundingGenerators[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %v", ErrUnknownGenerator, name)
+	}
+	return newGenerator(regex, rng)
+}
+
+// KeyGenerators returns the names of every key generator
+func KeyGenerators() []string {
+	return sortedNames(keyGenerators)
+}
+
+// PeerIdGenerators returns the names of every peer id generator
+func PeerIdGenerators() []string {
+	return sortedNames(peerIdGenerators)
+}
+
+// RoundingGenerators returns the names of every rounding generator
+func RoundingGenerators() []string {
+	return sortedNames(roundingGenerators)
+}
+
+func sortedNames[T any](generators map[string]T) []string {
+	names := make([]string, 0, len(generators))
+	for name := range generators {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,43 @@
+package generator
+
+import (
+	"errors"
+	"math/rand"
+	"testing"
+)
+
+func TestRegistry(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	if _, err := NewKeyGenerator(DefaultKeyGeneratorName, "", rng); err != nil {
+		t.Errorf("got: %v want nil", err)
+	}
+	if g, err := NewPeerIdGenerator(RegexPeerIdGeneratorName, "-XX0100-[a-z]{12}", rng); err != nil || len(g.PeerId()) != 20 {
+		t.Errorf("got: %v want a 20 bytes peer id", err)
+	}
+	if _, err := NewRoundingGenerator(DefaultRoundingGeneratorName, "", rng); err != nil {
+		t.Errorf("got: %v want nil", err)
+	}
+
+	data := []struct {
+		name string
+		err  error
+		want error
+	}{
+		{name: "unknown key generator", err: second(NewKeyGenerator("fancyKey", "", rng)), want: ErrUnknownGenerator},
+		{name: "unknown peer id generator", err: second(NewPeerIdGenerator("", "[a-z]{20}", rng)), want: ErrUnknownGenerator},
+		{name: "unknown rounding generator", err: second(NewRoundingGenerator("fancyRounding", "", rng)), want: ErrUnknownGenerator},
+		{name: "rounding regex not supported", err: second(NewRoundingGenerator(DefaultRoundingGeneratorName, "[0-9]+", rng)), want: ErrRegexNotSupported},
+		{name: "peer id regex required", err: second(NewPeerIdGenerator(RegexPeerIdGeneratorName, "", rng)), want: ErrRegexRequired},
+	}
+	for _, td := range data {
+		t.Run(td.name, func(t *testing.T) {
+			if !errors.Is(td.err, td.want) {
+				t.Errorf("got: %v want %v", td.err, td.want)
+			}
+		})
+	}
+}
+
+func second[T any](_ T, err error) error {
+	return err
+}
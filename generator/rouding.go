@@ -1,5 +1,8 @@
 package generator
 
+// blockSize is the size of the blocks pieces are requested in
+const blockSize = 16 * 1024
+
 type DefaultRoundingGenerator struct{}
 
 func NewDefaultRoudingGenerator() (*DefaultRoundingGenerator, error) {
@@ -7,10 +10,54 @@ func NewDefaultRoudingGenerator() (*DefaultRoundingGenerator, error) {
 
 }
 
+// Round leaves downloaded unchanged and rounds left down to whole pieces, downloaded+left is not kept to the torrent size.
+// The embedded profiles use the pieceRoundingGenerator instead, it is kept for the profiles that select it by name
 func (d *DefaultRoundingGenerator) Round(downloadCandidateNextAmount, uploadCandidateNextAmount, leftCandidateNextAmount, pieceSize int) (downloaded, uploaded, left int) {
 
 	down := downloadCandidateNextAmount
-	up := uploadCandidateNextAmount - (uploadCandidateNextAmount % (16 * 1024))
+	up := uploadCandidateNextAmount - (uploadCandidateNextAmount % blockSize)
 	l := leftCandidateNextAmount - (leftCandidateNextAmount % pieceSize)
 	return down, up, l
 }
+
+// AlignedRoundingGenerator only reports whole units of downloaded data, left is what remains of the torrent so
+// downloaded+left is always its total size. The last unit of the torrent may be short, it counts once complete
+type AlignedRoundingGenerator struct {
+	// unit returns the size downloaded is aligned to, 0 leaves it unchanged
+	unit func(pieceSize int) int
+}
+
+// NewBlockRoundingGenerator aligns downloaded to 16KiB blocks, like clients tracking the blocks they have
+func NewBlockRoundingGenerator() (*AlignedRoundingGenerator, error) {
+	return &AlignedRoundingGenerator{unit: func(pieceSize int) int { return blockSize }}, nil
+}
+
+// NewPieceRoundingGenerator aligns downloaded to whole pieces, like clients only counting the pieces that passed the hash check
+func NewPieceRoundingGenerator() (*AlignedRoundingGenerator, error) {
+	return &AlignedRoundingGenerator{unit: func(pieceSize int) int { return pieceSize }}, nil
+}
+
+// NewNoRoundingGenerator reports the amounts unchanged
+func NewNoRoundingGenerator() (*AlignedRoundingGenerator, error) {
+	return &AlignedRoundingGenerator{unit: func(pieceSize int) int { return 0 }}, nil
+}
+
+func (a *AlignedRoundingGenerator) Round(downloadCandidateNextAmount, uploadCandidateNextAmount, leftCandidateNextAmount, pieceSize int) (downloaded, uploaded, left int) {
+	unit := a.unit(pieceSize)
+	if unit == 0 {
+		return downloadCandidateNextAmount, uploadCandidateNextAmount, leftCandidateNextAmount
+	}
+	return alignedRound(downloadCandidateNextAmount, uploadCandidateNextAmount, leftCandidateNextAmount, unit)
+}
+
+// alignedRound rounds downloaded down to whole units unless the torrent is complete and uploaded down to whole blocks,
+// left is the rest of the torrent
+func alignedRound(downloadCandidateNextAmount, uploadCandidateNextAmount, leftCandidateNextAmount, unit int) (downloaded, uploaded, left int) {
+	total := downloadCandidateNextAmount + leftCandidateNextAmount
+	down := downloadCandidateNextAmount
+	if down < total {
+		down -= down % unit
+	}
+	up := uploadCandidateNextAmount - (uploadCandidateNextAmount % blockSize)
+	return down, up, total - down
+}
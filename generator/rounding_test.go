@@ -1,6 +1,9 @@
 package generator
 
-import "testing"
+import (
+	"math/rand"
+	"testing"
+)
 
 func TestDefaultRounding(t *testing.T) {
 	r, _ := NewDefaultRoudingGenerator()
@@ -19,3 +22,61 @@ func TestDefaultRounding(t *testing.T) {
 		t.Errorf("[left]got %v want %v", l, 7879680)
 	}
 }
+
+func TestAlignedRounding(t *testing.T) {
+	pieceSize := 256 * 1024
+	total := 10*pieceSize + 1000
+	data := []struct {
+		name       string
+		generator  string
+		downloaded int
+		want       int
+	}{
+		{name: "block aligned", generator: BlockRoundingGeneratorName, downloaded: 3*pieceSize + 20000, want: 3*pieceSize + blockSize},
+		{name: "piece aligned", generator: PieceRoundingGeneratorName, downloaded: 3*pieceSize + 20000, want: 3 * pieceSize},
+		{name: "piece aligned before the last short piece", generator: PieceRoundingGeneratorName, downloaded: total - 1, want: 10 * pieceSize},
+		{name: "piece aligned with the last short piece", generator: PieceRoundingGeneratorName, downloaded: total, want: total},
+		{name: "no rounding", generator: NoRoundingGeneratorName, downloaded: 3*pieceSize + 20000, want: 3*pieceSize + 20000},
+	}
+	for _, td := range data {
+		t.Run(td.name, func(t *testing.T) {
+			r, err := NewRoundingGenerator(td.generator, "", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			d, _, l := r.Round(td.downloaded, 0, total-td.downloaded, pieceSize)
+			if d != td.want || l != total-td.want {
+				t.Errorf("got: %v, %v want %v, %v", d, l, td.want, total-td.want)
+			}
+		})
+	}
+}
+
+// TestRoundingKeepsTotal checks on random torrents that every aligned rounding strategy reports amounts a client could have:
+// downloaded+left is the torrent size and nothing is rounded up. The default one rounds left on its own and is left out
+func TestRoundingKeepsTotal(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for _, name := range []string{BlockRoundingGeneratorName, PieceRoundingGeneratorName, NoRoundingGeneratorName} {
+		r, err := NewRoundingGenerator(name, "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 10000; i++ {
+			pieceSize := blockSize << rng.Intn(9)
+			total := 1 + rng.Intn(2000*pieceSize)
+			downloadCandidate := rng.Intn(total + 1)
+			uploadCandidate := rng.Intn(10 * total)
+
+			d, u, l := r.Round(downloadCandidate, uploadCandidate, total-downloadCandidate, pieceSize)
+			if d+l != total {
+				t.Fatalf("[%v]downloaded %v + left %v got %v want %v", name, d, l, d+l, total)
+			}
+			if d < 0 || d > downloadCandidate || u < 0 || u > uploadCandidate {
+				t.Fatalf("[%v]got: %v, %v should be rounded down from %v, %v", name, d, u, downloadCandidate, uploadCandidate)
+			}
+			if downloadCandidate == total && d != total {
+				t.Fatalf("[%v]a complete torrent got %v downloaded want %v", name, d, total)
+			}
+		}
+	}
+}
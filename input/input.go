@@ -7,6 +7,7 @@ import (
 	"math"
 	"strconv"
 	"strings"
+	"time"
 )
 
 const (
@@ -24,6 +25,14 @@ type InputArgs struct {
 	UploadSpeed       string
 	Port              int
 	Debug             bool
+	StateDir          string
+	StopRatio         float64
+	StopUploaded      string
+	StopAfter         time.Duration
+	StopAt            string
+	SpeedModel        string
+	SwarmAware        bool
+	ProfilesDir       string
 }
 
 type InputParsed struct {
@@ -34,12 +43,19 @@ type InputParsed struct {
 	UploadSpeed       int
 	Port              int
 	Debug             bool
+	StateDir          string
+	StopRatio         float64
+	StopUploaded      int
+	StopAfter         time.Duration
+	StopAt            time.Time
+	SwarmAware        bool
 }
 
 var validInitialSufixes = [...]string{"%", "b", "kb", "mb", "gb", "tb"}
 var validSpeedSufixes = [...]string{"kbps", "mbps"}
 
-func (i *InputArgs) ParseInput(torrentInfo *bencode.TorrentInfo) (*InputParsed, error) {
+// ParseInput validates the arguments against the torrent, the stop time is the next one after now
+func (i *InputArgs) ParseInput(torrentInfo *bencode.TorrentInfo, now time.Time) (*InputParsed, error) {
 	downloaded, err := extractInputInitialByteCount(i.InitialDownloaded, torrentInfo.TotalSize, true)
 	if err != nil {
 		return nil, err
@@ -58,7 +74,27 @@ func (i *InputArgs) ParseInput(torrentInfo *bencode.TorrentInfo) (*InputParsed,
 	}
 
 	if i.Port < minPortNumber || i.Port > maxPortNumber {
-		return nil, errors.New(fmt.Sprint("port number must be between %i and %i", minPortNumber, maxPortNumber))
+		return nil, fmt.Errorf("port number must be between %v and %v", minPortNumber, maxPortNumber)
+	}
+	if i.StopRatio < 0 {
+		return nil, errors.New("stop ratio can not be negative")
+	}
+	if i.StopAfter < 0 {
+		return nil, errors.New("stop duration can not be negative")
+	}
+	var stopUploaded int
+	if i.StopUploaded != "" {
+		stopUploaded, err = extractInputInitialByteCount(i.StopUploaded, torrentInfo.TotalSize, false)
+		if err != nil {
+			return nil, fmt.Errorf("invalid stop upload amount: %w", err)
+		}
+	}
+	var stopAt time.Time
+	if i.StopAt != "" {
+		stopAt, err = extractStopAt(i.StopAt, now)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	return &InputParsed{InitialDownloaded: downloaded,
@@ -67,9 +103,34 @@ func (i *InputArgs) ParseInput(torrentInfo *bencode.TorrentInfo) (*InputParsed,
 		UploadSpeed:     uploadSpeed,
 		Debug:           i.Debug,
 		Port:            i.Port,
+		StateDir:        i.StateDir,
+		StopRatio:       i.StopRatio,
+		StopUploaded:    stopUploaded,
+		StopAfter:       i.StopAfter,
+		StopAt:          stopAt,
+		SwarmAware:      i.SwarmAware,
 	}, nil
 }
 
+// extractStopAt parses a wall clock stop time, either a RFC3339 date or a HH:MM time of its next occurrence
+func extractStopAt(input string, now time.Time) (time.Time, error) {
+	if at, err := time.Parse(time.RFC3339, input); err == nil {
+		if !at.After(now) {
+			return time.Time{}, errors.New("stop time must be in the future")
+		}
+		return at, nil
+	}
+	clock, err := time.Parse("15:04", input)
+	if err != nil {
+		return time.Time{}, errors.New("stop time must be in HH:MM or RFC3339 format")
+	}
+	at := time.Date(now.Year(), now.Month(), now.Day(), clock.Hour(), clock.Minute(), 0, 0, now.Location())
+	if !at.After(now) {
+		at = at.AddDate(0, 0, 1)
+	}
+	return at, nil
+}
+
 func checkSpeedSufix(input string) (valid bool, suffix string) {
 	for _, v := range validSpeedSufixes {
 
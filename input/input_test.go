@@ -3,6 +3,7 @@ package input
 import (
 	"errors"
 	"testing"
+	"time"
 )
 
 func CheckError(out error, want error, t *testing.T) {
@@ -243,3 +244,49 @@ func TestExtractInputByteSpeed(T *testing.T) {
 		})
 	}
 }
+
+func TestExtractStopAt(T *testing.T) {
+	now := time.Date(2021, 5, 10, 22, 30, 0, 0, time.UTC)
+	data := []struct {
+		name     string
+		in       string
+		expected time.Time
+		err      error
+	}{
+		{
+			name:     "23:00 later today test",
+			in:       "23:00",
+			expected: time.Date(2021, 5, 10, 23, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "06:15 tomorrow test",
+			in:       "06:15",
+			expected: time.Date(2021, 5, 11, 6, 15, 0, 0, time.UTC),
+		},
+		{
+			name:     "RFC3339 test",
+			in:       "2021-05-12T08:00:00Z",
+			expected: time.Date(2021, 5, 12, 8, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "RFC3339 in the past test",
+			in:   "2021-05-09T08:00:00Z",
+			err:  errors.New("stop time must be in the future"),
+		},
+		{
+			name: "invalid time test",
+			in:   "tomorrow",
+			err:  errors.New("stop time must be in HH:MM or RFC3339 format"),
+		},
+	}
+
+	for _, td := range data {
+		T.Run(td.name, func(t *testing.T) {
+			got, err := extractStopAt(td.in, now)
+			CheckError(err, td.err, t)
+			if !got.Equal(td.expected) {
+				t.Errorf("got %v, want %v", got, td.expected)
+			}
+		})
+	}
+}
@@ -1,39 +1,73 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"github.com/ap-pauloafonso/ratio-spoof/bencode"
+	"github.com/ap-pauloafonso/ratio-spoof/emulation"
 	"github.com/ap-pauloafonso/ratio-spoof/input"
 	"github.com/ap-pauloafonso/ratio-spoof/printer"
 	"github.com/ap-pauloafonso/ratio-spoof/ratiospoof"
+	"github.com/ap-pauloafonso/ratio-spoof/tracker"
 	"log"
+	"math/rand"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
 )
 
-func main() {
+const exitStopCondition = 3
 
-	//required
-	torrentPath := flag.String("t", "", "torrent path")
-	initialDownload := flag.String("d", "", "a INITIAL_DOWNLOADED")
-	downloadSpeed := flag.String("ds", "", "a DOWNLOAD_SPEED")
-	initialUpload := flag.String("u", "", "a INITIAL_UPLOADED")
-	uploadSpeed := flag.String("us", "", "a UPLOAD_SPEED")
+// defaultSimulationStart is where the simulated clock starts, fixed so the same arguments and seed give the same history
+const defaultSimulationStart = "2024-01-01T00:00:00Z"
 
-	//optional
-	port := flag.Int("p", 8999, "a PORT")
-	debug := flag.Bool("debug", false, "")
-	client := flag.String("c", "qbit-4.0.3", "emulated client")
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "scrape" {
+		scrape(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 2 && os.Args[1] == "profiles" && os.Args[2] == "validate" {
+		validateProfiles(os.Args[3:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "simulate" {
+		simulate(os.Args[2:])
+		return
+	}
+
+	flags := newAnnounceFlags(flag.CommandLine)
+	stateDir := flag.String("state-dir", defaultStateDir(), "directory of the saved announce state")
+	noState := flag.Bool("no-state", false, "do not load nor save the announce state")
+	dryRun := flag.Bool("dry-run", false, "print the announces instead of sending them")
 
 	flag.Usage = func() {
 		fmt.Printf("usage: %s -t <TORRENT_PATH> -d <INITIAL_DOWNLOADED> -ds <DOWNLOAD_SPEED> -u <INITIAL_UPLOADED> -us <UPLOAD_SPEED>\n", os.Args[0])
+		fmt.Printf("       %s scrape -t <TORRENT_PATH> [-c CLIENT_CODE] [-profiles-dir DIR]\n", os.Args[0])
+		fmt.Printf("       %s profiles validate [-profiles-dir DIR]\n", os.Args[0])
+		fmt.Printf("       %s simulate -t <TORRENT_PATH> -d <INITIAL_DOWNLOADED> -ds <DOWNLOAD_SPEED> -u <INITIAL_UPLOADED> -us <UPLOAD_SPEED> [-n ANNOUNCES] [-seed SEED] [-start TIME]\n", os.Args[0])
 		fmt.Print(`
 optional arguments:
 	-h           		show this help message and exit
 	-p [PORT]    		change the port number, default: 8999
 	-c [CLIENT_CODE]	change the client emulation, default: qbit-4.0.3
+	-profiles-dir [DIR]	change where the client profiles overriding the embedded ones are read from, default: <user config dir>/ratio-spoof/profiles
+	-state-dir [DIR]	change where the announce state is saved, default: <user config dir>/ratio-spoof/state
+	-no-state		start from <INITIAL_DOWNLOADED>/<INITIAL_UPLOADED> with a new identity and do not save the state
+	-stop-ratio [RATIO]	stop once the uploaded amount reaches RATIO times the torrent size, example: 2.5
+	-stop-upload [SIZE]	stop once SIZE is uploaded, in %, b, kb, mb, gb, tb
+	-stop-after [DURATION]	stop after running for DURATION, example: 90m, 6h
+	-stop-at [TIME]		stop at TIME, in HH:MM (next occurrence) or RFC3339
+	-speed-model [MODEL]	change how the speeds vary between announces around <DOWNLOAD_SPEED> and <UPLOAD_SPEED>, default: constant
+	-swarm-aware		upload nothing without leechers, scale the upload down when seeders outnumber them and cap the uploaded total of the run to their demand
+	-dry-run		print the url and headers of every announce from started to stopped without contacting the tracker, only with a single -t
 	  
 required arguments:
-	-t  <TORRENT_PATH>     
+	-t  <TORRENT_PATH>      repeat it to announce several torrents as one client, speeds are split between them
 	-d  <INITIAL_DOWNLOADED> 
 	-ds <DOWNLOAD_SPEED>						  
 	-u  <INITIAL_UPLOADED> 
@@ -41,34 +75,287 @@ required arguments:
 	  
 <INITIAL_DOWNLOADED> and <INITIAL_UPLOADED> must be in %, b, kb, mb, gb, tb
 <DOWNLOAD_SPEED> and <UPLOAD_SPEED> must be in kbps, mbps
-[CLIENT_CODE] options: qbit-4.0.3, qbit-4.3.3
+the stopped announce is always sent, reaching a stop condition exits with status 3
+when a state was saved for the torrent, its counters take precedence over <INITIAL_DOWNLOADED> and <INITIAL_UPLOADED>, its peer id and key are only reused when the client profile persists them, like the default one does
+`)
+		fmt.Printf("[CLIENT_CODE] options: %v\n", strings.Join(clientCodes(*flags.profilesDir), ", "))
+		fmt.Print(`[MODEL] options: constant, uniform (±50%), gaussian (20% deviation), diurnal (peaks at 20:00), bursty (on/off at twice the speed)
+
+simulate arguments, on top of the ones above except -state-dir and -no-state:
+	-n [ANNOUNCES]		number of announces before the stopped one, default: 10
+	-seed [SEED]		seed of every random choice, default: 1
+	-interval [SECONDS]	interval answered by the simulated tracker, default: 1800
+	-seeders [COUNT]	seeders answered by the simulated tracker, default: 10
+	-leechers [COUNT]	leechers answered by the simulated tracker, default: 10
+	-start [TIME]		time the simulated clock starts at, in RFC3339, -stop-at is relative to it, default: 2024-01-01T00:00:00Z
 `)
 	}
 
 	flag.Parse()
 
-	if *torrentPath == "" || *initialDownload == "" || *downloadSpeed == "" || *initialUpload == "" || *uploadSpeed == "" {
+	if !flags.valid() {
 		flag.Usage()
 		return
 	}
 
-	r, err := ratiospoof.NewRatioSpoofState(
-		input.InputArgs{
-			TorrentPath:       *torrentPath,
-			InitialDownloaded: *initialDownload,
-			DownloadSpeed:     *downloadSpeed,
-			InitialUploaded:   *initialUpload,
-			UploadSpeed:       *uploadSpeed,
-			Port:              *port,
-			Debug:             *debug,
-			Client:            *client,
-		})
+	args := flags.args()
+	args.StateDir = *stateDir
+	if *noState {
+		args.StateDir = ""
+	}
+
+	if *dryRun {
+		if len(flags.torrentPaths) > 1 {
+			log.Fatalln("-dry-run renders the announces of a single torrent, give only one -t")
+		}
+		announces, err := ratiospoof.DryRun(args)
+		printer.PrintDryRun(announces)
+		if err != nil && !errors.Is(err, ratiospoof.ErrStopConditionReached) {
+			log.Fatalln(err)
+		}
+		return
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
+	if len(flags.torrentPaths) > 1 {
+		session, err := ratiospoof.NewSession(args, flags.torrentPaths)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		go printer.PrintSession(session)
+		exit(runGracefully(ctx, session.Run))
+		return
+	}
+
+	r, err := ratiospoof.NewRatioSpoofState(args)
 	if err != nil {
 		log.Fatalln(err)
 	}
 
 	go printer.PrintState(r)
-	r.Run()
+	exit(runGracefully(ctx, r.Run))
+}
+
+// runGracefully runs the torrents and tells the user once about the stopped announces, whatever the amount of torrents
+func runGracefully(ctx context.Context, run func(ctx context.Context) error) error {
+	finished := make(chan struct{})
+	told := make(chan struct{})
+	go func() {
+		defer close(told)
+		select {
+		case <-ctx.Done():
+			fmt.Printf("\nGracefully exiting...\n")
+		case <-finished:
+		}
+	}()
+	err := run(ctx)
+	close(finished)
+	<-told
+	if err == nil || errors.Is(err, ratiospoof.ErrStopConditionReached) {
+		fmt.Printf("Gracefully exited successfully.\n")
+	}
+	return err
+}
+
+// exit ends the process with an error status when the run failed and with exitStopCondition when a stop condition ended it
+func exit(err error) {
+	if err == nil {
+		return
+	}
+	if errors.Is(err, ratiospoof.ErrStopConditionReached) {
+		fmt.Println(err)
+		os.Exit(exitStopCondition)
+	}
+	log.Fatalln(err)
+}
+
+// clientCodes returns the codes of the embedded profiles and of the ones in the profiles directory
+func clientCodes(profilesDir string) []string {
+	codes, err := emulation.Profiles{Dir: profilesDir}.Codes()
+	if err != nil {
+		log.Fatalln(err)
+	}
+	return codes
+}
 
+// defaultProfilesDir returns where the client profiles are read from when -profiles-dir is not given
+func defaultProfilesDir() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "ratio-spoof", "profiles")
+}
+
+// defaultStateDir returns where the announce state is saved when -state-dir is not given
+func defaultStateDir() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "ratio-spoof", "state")
+}
+
+// announceFlags are the flags shared by the run and the simulate commands
+type announceFlags struct {
+	torrentPaths    torrentPathsFlag
+	initialDownload *string
+	downloadSpeed   *string
+	initialUpload   *string
+	uploadSpeed     *string
+	port            *int
+	debug           *bool
+	client          *string
+	stopRatio       *float64
+	stopUpload      *string
+	stopAfter       *time.Duration
+	stopAt          *string
+	speedModel      *string
+	swarmAware      *bool
+	profilesDir     *string
+}
+
+func newAnnounceFlags(flags *flag.FlagSet) *announceFlags {
+	f := &announceFlags{}
+	//required
+	flags.Var(&f.torrentPaths, "t", "torrent path, repeat it to run a multi-torrent session")
+	f.initialDownload = flags.String("d", "", "a INITIAL_DOWNLOADED")
+	f.downloadSpeed = flags.String("ds", "", "a DOWNLOAD_SPEED")
+	f.initialUpload = flags.String("u", "", "a INITIAL_UPLOADED")
+	f.uploadSpeed = flags.String("us", "", "a UPLOAD_SPEED")
+
+	//optional
+	f.port = flags.Int("p", 8999, "a PORT")
+	f.debug = flags.Bool("debug", false, "")
+	f.client = flags.String("c", "qbit-4.0.3", "emulated client")
+	f.stopRatio = flags.Float64("stop-ratio", 0, "stop once uploaded reaches this ratio of the torrent size")
+	f.stopUpload = flags.String("stop-upload", "", "stop once this amount is uploaded")
+	f.stopAfter = flags.Duration("stop-after", 0, "stop after running for this duration")
+	f.stopAt = flags.String("stop-at", "", "stop at this time")
+	f.speedModel = flags.String("speed-model", ratiospoof.DefaultSpeedModel, "how the speed varies between announces")
+	f.swarmAware = flags.Bool("swarm-aware", false, "scale the upload to the seeders and leechers reported by the tracker")
+	f.profilesDir = flags.String("profiles-dir", defaultProfilesDir(), "directory of client profiles overriding the embedded ones")
+	return f
+}
+
+func (f *announceFlags) valid() bool {
+	return len(f.torrentPaths) > 0 && *f.initialDownload != "" && *f.downloadSpeed != "" && *f.initialUpload != "" && *f.uploadSpeed != ""
+}
+
+func (f *announceFlags) args() input.InputArgs {
+	return input.InputArgs{
+		TorrentPath:       f.torrentPaths[0],
+		InitialDownloaded: *f.initialDownload,
+		DownloadSpeed:     *f.downloadSpeed,
+		InitialUploaded:   *f.initialUpload,
+		UploadSpeed:       *f.uploadSpeed,
+		Port:              *f.port,
+		Debug:             *f.debug,
+		Client:            *f.client,
+		StopRatio:         *f.stopRatio,
+		StopUploaded:      *f.stopUpload,
+		StopAfter:         *f.stopAfter,
+		StopAt:            *f.stopAt,
+		SpeedModel:        *f.speedModel,
+		SwarmAware:        *f.swarmAware,
+		ProfilesDir:       *f.profilesDir,
+	}
+}
+
+// torrentPathsFlag collects every -t occurrence
+type torrentPathsFlag []string
+
+func (t *torrentPathsFlag) String() string {
+	return strings.Join(*t, ",")
+}
+
+func (t *torrentPathsFlag) Set(value string) error {
+	*t = append(*t, value)
+	return nil
+}
+
+func scrape(args []string) {
+	flags := flag.NewFlagSet("scrape", flag.ExitOnError)
+	torrentPath := flags.String("t", "", "torrent path")
+	client := flags.String("c", "qbit-4.0.3", "emulated client")
+	profilesDir := flags.String("profiles-dir", defaultProfilesDir(), "directory of client profiles overriding the embedded ones")
+	flags.Parse(args)
+
+	if *torrentPath == "" {
+		flag.Usage()
+		return
+	}
+
+	dat, err := os.ReadFile(*torrentPath)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	torrentInfo, err := bencode.TorrentDictParse(dat)
+	if err != nil {
+		log.Fatalln("failed to parse the torrent file:", err)
+	}
+	emulatedClient, err := emulation.Profiles{Dir: *profilesDir}.NewEmulation(*client, rand.New(rand.NewSource(time.Now().UnixNano())))
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	printer.PrintScrape(torrentInfo, tracker.ScrapeAll(context.Background(), torrentInfo, emulatedClient.Headers))
+}
+
+func simulate(args []string) {
+	flags := flag.NewFlagSet("simulate", flag.ExitOnError)
+	announce := newAnnounceFlags(flags)
+	announces := flags.Int("n", 10, "number of announces")
+	seed := flags.Int64("seed", 1, "seed of every random choice")
+	interval := flags.Int("interval", 1800, "interval of the simulated tracker")
+	seeders := flags.Int("seeders", 10, "seeders of the simulated tracker")
+	leechers := flags.Int("leechers", 10, "leechers of the simulated tracker")
+	start := flags.String("start", defaultSimulationStart, "time the simulated clock starts at, in RFC3339")
+	flags.Parse(args)
+
+	if !announce.valid() {
+		flag.Usage()
+		return
+	}
+	startTime, err := time.Parse(time.RFC3339, *start)
+	if err != nil {
+		log.Fatalln("the simulation start must be in RFC3339 format")
+	}
+
+	began := time.Now()
+	result, err := ratiospoof.Simulate(announce.args(), ratiospoof.Simulation{
+		Announces: *announces,
+		Seed:      *seed,
+		Start:     startTime,
+		Response:  tracker.TrackerResponse{Interval: *interval, Seeders: *seeders, Leechers: *leechers},
+	})
+	printer.PrintSimulation(result, time.Since(began))
+	exit(err)
+}
+
+func validateProfiles(args []string) {
+	flags := flag.NewFlagSet("profiles validate", flag.ExitOnError)
+	profilesDir := flags.String("profiles-dir", defaultProfilesDir(), "directory of client profiles overriding the embedded ones")
+	flags.Parse(args)
+
+	invalid, err := emulation.Profiles{Dir: *profilesDir}.Validate()
+	if err != nil {
+		log.Fatalln(err)
+	}
+	for _, err := range invalid {
+		var profileErr *emulation.InvalidProfileError
+		if !errors.As(err, &profileErr) {
+			fmt.Println(err)
+			continue
+		}
+		for _, problem := range profileErr.Problems {
+			fmt.Printf("%v: %v\n", profileErr.Source, problem)
+		}
+	}
+	if len(invalid) > 0 {
+		os.Exit(1)
+	}
+	fmt.Println("every client profile is valid")
 }
@@ -2,10 +2,13 @@ package printer
 
 import (
 	"fmt"
+	"github.com/ap-pauloafonso/ratio-spoof/bencode"
 	"github.com/ap-pauloafonso/ratio-spoof/ratiospoof"
+	"github.com/ap-pauloafonso/ratio-spoof/tracker"
 	"os"
 	"os/exec"
 	"runtime"
+	"sort"
 	"strings"
 	"time"
 
@@ -35,20 +38,19 @@ func PrintState(state *ratiospoof.RatioSpoof) {
 			if state.Leechers == 0 {
 				leechersStr = "not informed"
 			}
-			var retryStr string
-			if state.Tracker.RetryAttempt > 0 {
-				retryStr = fmt.Sprintf("(*Retry %v - check your connection)", state.Tracker.RetryAttempt)
-			}
+			trackerStatus := state.Tracker.Status()
+			retryStr := retryString(trackerStatus)
 			fmt.Printf("%s\n", center("  RATIO-SPOOF  ", width-len("  RATIO-SPOOF  "), "#"))
 			fmt.Printf(`
 	Torrent: %v
 	Tracker: %v
 	Seeders: %v
 	Leechers:%v
+	Peers: %v
 	Download Speed: %v/s
 	Upload Speed: %v/s
 	Size: %v
-	Emulation: %v | Port: %v`, state.TorrentInfo.Name, state.TorrentInfo.TrackerInfo.Main, seedersStr, leechersStr, humanReadableSize(float64(state.Input.DownloadSpeed)),
+	Emulation: %v | Port: %v`, state.TorrentInfo.Name, trackerStatus.Url, seedersStr, leechersStr, state.Peers, humanReadableSize(float64(state.Input.DownloadSpeed)),
 				humanReadableSize(float64(state.Input.UploadSpeed)), humanReadableSize(float64(state.TorrentInfo.TotalSize)), state.BitTorrentClient.Name, state.Input.Port)
 			fmt.Printf("\n\n%s\n\n", center("  GITHUB.COM/AP-PAULOAFONSO/RATIO-SPOOF  ", width-len("  GITHUB.COM/AP-PAULOAFONSO/RATIO-SPOOF  "), "#"))
 			for i := 0; i <= state.AnnounceHistory.Len()-2; i++ {
@@ -57,7 +59,7 @@ func PrintState(state *ratiospoof.RatioSpoof) {
 			}
 			lastDequeItem := state.AnnounceHistory.At(state.AnnounceHistory.Len() - 1).(ratiospoof.AnnounceEntry)
 
-			remaining := time.Until(state.Tracker.EstimatedTimeToAnnounce)
+			remaining := time.Until(trackerStatus.EstimatedTimeToAnnounce)
 			fmt.Printf("#%v downloaded: %v(%.2f%%) | left: %v | uploaded: %v | next announce in: %v %v\n", lastDequeItem.Count,
 				humanReadableSize(float64(lastDequeItem.Downloaded)),
 				lastDequeItem.PercentDownloaded,
@@ -66,15 +68,87 @@ func PrintState(state *ratiospoof.RatioSpoof) {
 				fmtDuration(remaining),
 				retryStr)
 
+			if trackerStatus.LastWarning != "" {
+				fmt.Printf("\nTracker warning: %v\n", trackerStatus.LastWarning)
+			}
+
 			if state.Input.Debug {
 				fmt.Printf("\n%s\n", center("  DEBUG  ", width-len("  DEBUG  "), "#"))
-				fmt.Printf("\n%s\n\n%s", state.Tracker.LastAnounceRequest, state.Tracker.LastTackerResponse)
+				fmt.Printf("\n%s\n\n%s", trackerStatus.LastAnounceRequest, trackerStatus.LastTackerResponse)
 			}
 			time.Sleep(1 * time.Second)
 		}
 	}
 }
 
+func PrintSession(session *ratiospoof.Session) {
+	for running(session) {
+		width := terminalSize()
+		clear()
+
+		fmt.Printf("%s\n", center("  RATIO-SPOOF  ", width-len("  RATIO-SPOOF  "), "#"))
+		fmt.Printf(`
+	Torrents: %v
+	Download Speed: %v/s
+	Upload Speed: %v/s
+	Emulation: %v | Port: %v`, len(session.Torrents), humanReadableSize(float64(session.DownloadSpeed)), humanReadableSize(float64(session.UploadSpeed)),
+			session.BitTorrentClient.Name, session.Torrents[0].Input.Port)
+		fmt.Printf("\n\n%s\n", center("  GITHUB.COM/AP-PAULOAFONSO/RATIO-SPOOF  ", width-len("  GITHUB.COM/AP-PAULOAFONSO/RATIO-SPOOF  "), "#"))
+
+		for _, state := range session.Torrents {
+			fmt.Printf("\n%v (%v)\n", state.TorrentInfo.Name, humanReadableSize(float64(state.TorrentInfo.TotalSize)))
+			if state.AnnounceCount <= 1 {
+				fmt.Println("	Trying to connect to the tracker...")
+				continue
+			}
+			trackerStatus := state.Tracker.Status()
+			fmt.Printf("	Tracker: %v | Seeders: %v | Leechers: %v | Peers: %v\n", trackerStatus.Url, state.Seeders, state.Leechers, state.Peers)
+			lastDequeItem := state.AnnounceHistory.Back().(ratiospoof.AnnounceEntry)
+			fmt.Printf("	#%v downloaded: %v(%.2f%%) | left: %v | uploaded: %v | next announce in: %v %v\n", lastDequeItem.Count,
+				humanReadableSize(float64(lastDequeItem.Downloaded)),
+				lastDequeItem.PercentDownloaded,
+				humanReadableSize(float64(lastDequeItem.Left)),
+				humanReadableSize(float64(lastDequeItem.Uploaded)),
+				fmtDuration(time.Until(trackerStatus.EstimatedTimeToAnnounce)),
+				retryString(trackerStatus))
+			if trackerStatus.LastWarning != "" {
+				fmt.Printf("	Tracker warning: %v\n", trackerStatus.LastWarning)
+			}
+		}
+		time.Sleep(1 * time.Second)
+	}
+}
+
+func running(session *ratiospoof.Session) bool {
+	for _, state := range session.Torrents {
+		if state.Print {
+			return true
+		}
+	}
+	return false
+}
+
+func retryString(trackerStatus tracker.Status) string {
+	if trackerStatus.RetryAttempt == 0 {
+		return ""
+	}
+	if trackerStatus.LastError != "" {
+		return fmt.Sprintf("(*Retry %v - %v)", trackerStatus.RetryAttempt, trackerStatus.LastError)
+	}
+	return fmt.Sprintf("(*Retry %v - check your connection)", trackerStatus.RetryAttempt)
+}
+
+func PrintScrape(torrentInfo *bencode.TorrentInfo, results []tracker.ScrapeResult) {
+	fmt.Printf("Torrent: %v\n\n", torrentInfo.Name)
+	for _, result := range results {
+		if result.Err != nil {
+			fmt.Printf("%v | error: %v\n", result.Url, result.Err)
+			continue
+		}
+		fmt.Printf("%v | seeders: %v | leechers: %v | completed: %v\n", result.Url, result.Response.Seeders, result.Response.Leechers, result.Response.Completed)
+	}
+}
+
 func terminalSize() int {
 	size, _ := ts.GetSize()
 	width := size.Col()
@@ -116,3 +190,36 @@ func fmtDuration(d time.Duration) string {
 	}
 	return fmt.Sprintf("%s", time.Duration(int(d.Seconds()))*time.Second)
 }
+
+func PrintSimulation(announces []ratiospoof.SimulatedAnnounce, elapsed time.Duration) {
+	for i, announce := range announces {
+		event := announce.Event
+		if event == "" {
+			event = "-"
+		}
+		fmt.Printf("#%v %v | event: %v | downloaded: %v | left: %v | uploaded: %v\n", i+1, announce.Time.Format("2006-01-02 15:04:05"), event,
+			humanReadableSize(float64(announce.Downloaded)),
+			humanReadableSize(float64(announce.Left)),
+			humanReadableSize(float64(announce.Uploaded)))
+	}
+	fmt.Printf("\n%v announces simulated in %vms\n", len(announces), elapsed.Milliseconds())
+}
+
+func PrintDryRun(announces []ratiospoof.SimulatedAnnounce) {
+	for i, announce := range announces {
+		event := announce.Event
+		if event == "" {
+			event = "regular"
+		}
+		fmt.Printf("#%v %v (%v)\nGET %v\n", i+1, event, announce.Time.Format("2006-01-02 15:04:05"), announce.Url)
+		headers := make([]string, 0, len(announce.Headers))
+		for name := range announce.Headers {
+			headers = append(headers, name)
+		}
+		sort.Strings(headers)
+		for _, name := range headers {
+			fmt.Printf("%v: %v\n", name, announce.Headers[name])
+		}
+		fmt.Println()
+	}
+}
@@ -1,48 +1,65 @@
 package ratiospoof
 
 import (
-	"errors"
+	"context"
 	"fmt"
 	"github.com/ap-pauloafonso/ratio-spoof/bencode"
+	"github.com/ap-pauloafonso/ratio-spoof/clock"
 	"github.com/ap-pauloafonso/ratio-spoof/emulation"
 	"github.com/ap-pauloafonso/ratio-spoof/input"
 	"github.com/ap-pauloafonso/ratio-spoof/tracker"
-	"log"
 	"math/rand"
+	"net/url"
 	"os"
-	"os/signal"
 	"strings"
-	"syscall"
 	"time"
 
 	"github.com/gammazero/deque"
 )
 
 const (
-	maxAnnounceHistory = 10
+	maxAnnounceHistory     = 10
+	stoppedAnnounceTimeout = 30 * time.Second
+)
+
+// announce events, a regular announce has no event
+const (
+	eventNone      = ""
+	eventStarted   = "started"
+	eventCompleted = "completed"
+	eventStopped   = "stopped"
 )
 
 type RatioSpoof struct {
 	TorrentInfo      *bencode.TorrentInfo
 	Input            *input.InputParsed
-	Tracker          *tracker.HttpTracker
+	Tracker          tracker.Tracker
 	BitTorrentClient *emulation.Emulation
 	AnnounceInterval int
+	TrackerId        string
 	NumWant          int
 	Seeders          int
 	Leechers         int
+	Peers            int
 	AnnounceCount    int
 	Status           string
 	AnnounceHistory  announceHistory
 	Print            bool
+	DownloadModel    SpeedModel
+	UploadModel      SpeedModel
+	session          *Session
+	// swarmUploadStart is the uploaded amount the run started from, the swarm-aware cap bounds what the run adds to it
+	swarmUploadStart int
+	clock            clock.Clock
+	rng              *rand.Rand
 }
 
 type AnnounceEntry struct {
-	Count             int
-	Downloaded        int
-	PercentDownloaded float32
-	Uploaded          int
-	Left              int
+	Count             int     `json:"count"`
+	Downloaded        int     `json:"downloaded"`
+	PercentDownloaded float32 `json:"percentDownloaded"`
+	Uploaded          int     `json:"uploaded"`
+	Left              int     `json:"left"`
 }
 
 type announceHistory struct {
@@ -50,40 +67,78 @@ type announceHistory struct {
 }
 
 func NewRatioSpoofState(input input.InputArgs) (*RatioSpoof, error) {
+	rng := newRand()
+	client, err := emulation.Profiles{Dir: input.ProfilesDir}.NewEmulation(input.Client, rng)
+	if err != nil {
+		return nil, fmt.Errorf("error building the emulated client with the code %v: %w", input.Client, err)
+	}
+	return newRatioSpoof(input, client, clock.Real, rng)
+}
+
+// newRand returns a random source seeded with the current time, for runs that do not need to be reproducible
+func newRand() *rand.Rand {
+	return rand.New(rand.NewSource(time.Now().UnixNano()))
+}
+
+// newRatioSpoof loads the torrent of the input and announces it as the given emulated client, every wait goes through the clock
+// and every random choice comes from rng, which must not be shared with another goroutine
+func newRatioSpoof(input input.InputArgs, client *emulation.Emulation, clk clock.Clock, rng *rand.Rand) (*RatioSpoof, error) {
 	dat, err := os.ReadFile(input.TorrentPath)
 	if err != nil {
 		return nil, err
 	}
 
-	client, err := emulation.NewEmulation(input.Client)
+	torrentInfo, err := bencode.TorrentDictParse(dat)
 	if err != nil {
-		return nil, errors.New("Error building the emulated client with the code")
+		return nil, fmt.Errorf("failed to parse the torrent file: %w", err)
 	}
 
-	torrentInfo, err := bencode.TorrentDictParse(dat)
+	trackerClient, err := tracker.NewTracker(torrentInfo, clk, rng)
 	if err != nil {
-		return nil, errors.New("failed to parse the torrent file")
+		return nil, err
 	}
 
-	httpTracker, err := tracker.NewHttpTracker(torrentInfo)
+	inputParsed, err := input.ParseInput(torrentInfo, clk.Now())
 	if err != nil {
 		return nil, err
 	}
 
-	inputParsed, err := input.ParseInput(torrentInfo)
+	speedModel := input.SpeedModel
+	if speedModel == "" {
+		speedModel = DefaultSpeedModel
+	}
+	downloadModel, err := NewSpeedModel(speedModel, rng)
+	if err != nil {
+		return nil, err
+	}
+	uploadModel, err := NewSpeedModel(speedModel, rng)
 	if err != nil {
 		return nil, err
 	}
 
-	return &RatioSpoof{
+	r := &RatioSpoof{
 		BitTorrentClient: client,
 		TorrentInfo:      torrentInfo,
-		Tracker:          httpTracker,
+		Tracker:          trackerClient,
 		Input:            inputParsed,
-		NumWant:          200,
-		Status:           "started",
+		NumWant:          client.NumWant,
+		Status:           eventStarted,
 		Print:            true,
-	}, nil
+		DownloadModel:    downloadModel,
+		UploadModel:      uploadModel,
+		clock:            clk,
+		rng:              rng,
+	}
+	if inputParsed.StateDir != "" {
+		state, err := loadState(inputParsed.StateDir, torrentInfo.InfoHashURLEncoded)
+		if err != nil {
+			return nil, err
+		}
+		if state != nil {
+			r.restoreState(state)
+		}
+	}
+	return r, nil
 }
 
 func (a *announceHistory) pushValueHistory(value AnnounceEntry) {
@@ -93,45 +148,78 @@ func (a *announceHistory) pushValueHistory(value AnnounceEntry) {
 	a.PushBack(value)
 }
 
-func (r *RatioSpoof) gracefullyExit() {
-	fmt.Printf("\nGracefully exiting...\n")
-	r.Status = "stopped"
+func (r *RatioSpoof) gracefullyExit() error {
+	// the printer stops, main tells the user about the stopped announces once whatever the amount of torrents
+	r.Print = false
+	r.Status = eventStopped
 	r.NumWant = 0
-	r.fireAnnounce(false)
-	fmt.Printf("Gracefully exited successfully.\n")
-
+	// the run context is already done, the stopped announce gets its own deadline
+	ctx, cancel := context.WithTimeout(context.Background(), stoppedAnnounceTimeout)
+	defer cancel()
+	return r.fireAnnounce(ctx, false)
 }
 
-func (r *RatioSpoof) Run() {
-	sigCh := make(chan os.Signal)
-
-	signal.Notify(sigCh, os.Interrupt, syscall.SIGINT, syscall.SIGTERM)
-	r.firstAnnounce()
-	go func() {
-		for {
-			r.generateNextAnnounce()
-			time.Sleep(time.Duration(r.AnnounceInterval) * time.Second)
-			r.fireAnnounce(true)
+// Run announces until the context is done or a stop condition is reached, then sends the stopped announce and returns.
+// Reaching a stop condition returns an error wrapping ErrStopConditionReached
+func (r *RatioSpoof) Run(ctx context.Context) error {
+	deadline := r.stopDeadline(r.clock.Now())
+	if err := r.firstAnnounce(ctx); err != nil {
+		if ctx.Err() != nil {
+			// the tracker may have registered the started announce, it is always closed by the stopped one
+			return r.gracefullyExit()
 		}
-	}()
-	<-sigCh
-	r.Print = false
-	r.gracefullyExit()
+		return err
+	}
+	for {
+		if reason := r.stopReason(); reason != "" {
+			return r.stop(reason)
+		}
+		r.generateNextAnnounce()
+		waitStarted := r.clock.Now()
+		wait := time.Duration(r.AnnounceInterval) * time.Second
+		timeLimit := !deadline.IsZero() && waitStarted.Add(wait).After(deadline)
+		if timeLimit {
+			wait = deadline.Sub(waitStarted)
+		}
+		select {
+		case <-ctx.Done():
+			r.regenerateLastAnnounce(r.clock.Now().Sub(waitStarted))
+			return r.gracefullyExit()
+		case <-r.clock.After(wait):
+		}
+		if timeLimit {
+			r.regenerateLastAnnounce(wait)
+			return r.stop("time limit")
+		}
+		if err := r.fireAnnounce(ctx, true); err != nil {
+			if ctx.Err() != nil {
+				return r.gracefullyExit()
+			}
+			return err
+		}
+	}
 }
-func (r *RatioSpoof) firstAnnounce() {
-	r.addAnnounce(r.Input.InitialDownloaded, r.Input.InitialUploaded, calculateBytesLeft(r.Input.InitialDownloaded, r.TorrentInfo.TotalSize), (float32(r.Input.InitialDownloaded)/float32(r.TorrentInfo.TotalSize))*100)
-	r.fireAnnounce(false)
+func (r *RatioSpoof) firstAnnounce(ctx context.Context) error {
+	if err := r.BitTorrentClient.Restart(r.rng); err != nil {
+		return err
+	}
+	// the client profile decides how the initial amounts are aligned, like every other announce
+	d, u, l := r.BitTorrentClient.Round(r.Input.InitialDownloaded, r.Input.InitialUploaded, calculateBytesLeft(r.Input.InitialDownloaded, r.TorrentInfo.TotalSize), r.TorrentInfo.PieceSize)
+	r.swarmUploadStart = u
+	r.addAnnounce(d, u, l, (float32(d)/float32(r.TorrentInfo.TotalSize))*100)
+	return r.fireAnnounce(ctx, false)
 }
 
 func (r *RatioSpoof) updateSeedersAndLeechers(resp tracker.TrackerResponse) {
 	r.Seeders = resp.Seeders
 	r.Leechers = resp.Leechers
+	r.Peers = len(resp.Peers)
 }
 func (r *RatioSpoof) addAnnounce(currentDownloaded, currentUploaded, currentLeft int, percentDownloaded float32) {
 	r.AnnounceCount++
 	r.AnnounceHistory.pushValueHistory(AnnounceEntry{Count: r.AnnounceCount, Downloaded: currentDownloaded, Uploaded: currentUploaded, Left: currentLeft, PercentDownloaded: percentDownloaded})
 }
-func (r *RatioSpoof) fireAnnounce(retry bool) error {
+func (r *RatioSpoof) fireAnnounce(ctx context.Context, retry bool) error {
 	lastAnnounce := r.AnnounceHistory.Back().(AnnounceEntry)
 	replacer := strings.NewReplacer("{infohash}", r.TorrentInfo.InfoHashURLEncoded,
 		"{port}", fmt.Sprint(r.Input.Port),
@@ -140,45 +228,122 @@ func (r *RatioSpoof) fireAnnounce(retry bool) error {
 		"{downloaded}", fmt.Sprint(lastAnnounce.Downloaded),
 		"{left}", fmt.Sprint(lastAnnounce.Left),
 		"{key}", r.BitTorrentClient.Key(),
-		"{event}", r.Status,
-		"{numwant}", fmt.Sprint(r.NumWant))
+		"{event}", r.eventParam(),
+		"{numwant}", fmt.Sprint(r.NumWant),
+		"{trackerid}", r.trackerIdParam())
 	query := replacer.Replace(r.BitTorrentClient.Query)
-	trackerResp, err := r.Tracker.Announce(query, r.BitTorrentClient.Headers, retry)
+	trackerResp, err := r.Tracker.Announce(ctx, query, r.BitTorrentClient.Headers, retry)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if tracker.IsPermanent(err) {
+		return fmt.Errorf("the tracker rejected the announce: %w", err)
+	}
 	if err != nil {
-		log.Fatalf("failed to reach the tracker:\n%s ", err.Error())
+		return fmt.Errorf("failed to reach the tracker: %w", err)
 	}
 
+	// started and completed are sent once, the announces after them are regular ones
+	if r.Status != eventStopped {
+		r.Status = eventNone
+	}
 	if trackerResp != nil {
 		r.updateSeedersAndLeechers(*trackerResp)
 		r.AnnounceInterval = trackerResp.Interval
+		// BEP 3: the last tracker id received is kept when the tracker stops sending it
+		if trackerResp.TrackerId != "" {
+			r.TrackerId = trackerResp.TrackerId
+		}
+	}
+	if err := r.saveState(); err != nil {
+		return fmt.Errorf("failed to save the state: %w", err)
 	}
 	return nil
 }
+
+// trackerIdParam renders the {trackerid} placeholder, like qBittorrent the parameter is only sent
+// once the tracker has given us an id
+func (r *RatioSpoof) trackerIdParam() string {
+	if r.TrackerId == "" {
+		return ""
+	}
+	return "&trackerid=" + url.QueryEscape(r.TrackerId)
+}
+
+// eventParam renders the {event} placeholder, regular announces do not send the parameter at all
+func (r *RatioSpoof) eventParam() string {
+	if r.Status == eventNone {
+		return ""
+	}
+	return "&event=" + r.Status
+}
+
+// generateNextAnnounce adds the announce sent once the announce interval has passed
 func (r *RatioSpoof) generateNextAnnounce() {
+	r.generateAnnounceAfter(r.AnnounceInterval)
+}
+
+// regenerateLastAnnounce replaces the announce generated for the whole interval by one for the time that actually
+// passed, when the wait was cut short the amounts must not go beyond what the speeds allow
+func (r *RatioSpoof) regenerateLastAnnounce(elapsed time.Duration) {
+	r.AnnounceHistory.PopBack()
+	r.AnnounceCount--
+	if r.Status == eventCompleted {
+		r.Status = eventNone
+	}
+	r.generateAnnounceAfter(int(elapsed / time.Second))
+}
+
+// generateAnnounceAfter adds the announce sent after transferring for the given seconds
+func (r *RatioSpoof) generateAnnounceAfter(seconds int) {
 	lastAnnounce := r.AnnounceHistory.Back().(AnnounceEntry)
 	currentDownloaded := lastAnnounce.Downloaded
 	var downloadCandidate int
 
+	downloadSpeed, uploadSpeed := r.speeds()
+	now := r.clock.Now()
+	downloadSpeed = r.DownloadModel.Speed(downloadSpeed, now)
+	uploadSpeed = r.UploadModel.Speed(uploadSpeed, now)
+	if r.Input.SwarmAware {
+		uploadSpeed = r.swarmUploadSpeed(uploadSpeed)
+	}
 	if currentDownloaded < r.TorrentInfo.TotalSize {
-		randomPiecesDownload := rand.Intn(10-1) + 1
-		downloadCandidate = calculateNextTotalSizeByte(r.Input.DownloadSpeed, currentDownloaded, r.TorrentInfo.PieceSize, r.AnnounceInterval, r.TorrentInfo.TotalSize, randomPiecesDownload)
+		randomPiecesDownload := r.rng.Intn(10-1) + 1
+		downloadCandidate = calculateNextTotalSizeByte(downloadSpeed, currentDownloaded, r.TorrentInfo.PieceSize, seconds, r.TorrentInfo.TotalSize, randomPiecesDownload)
 	} else {
 		downloadCandidate = r.TorrentInfo.TotalSize
 	}
 
 	currentUploaded := lastAnnounce.Uploaded
-	randomPiecesUpload := rand.Intn(10-1) + 1
-	uploadCandidate := calculateNextTotalSizeByte(r.Input.UploadSpeed, currentUploaded, r.TorrentInfo.PieceSize, r.AnnounceInterval, 0, randomPiecesUpload)
+	randomPiecesUpload := r.rng.Intn(10-1) + 1
+	uploadCandidate := calculateNextTotalSizeByte(uploadSpeed, currentUploaded, r.TorrentInfo.PieceSize, seconds, 0, randomPiecesUpload)
+	if r.Input.SwarmAware {
+		uploadCandidate = r.swarmCappedUpload(currentUploaded, uploadCandidate)
+	}
 
 	leftCandidate := calculateBytesLeft(downloadCandidate, r.TorrentInfo.TotalSize)
 
 	d, u, l := r.BitTorrentClient.Round(downloadCandidate, uploadCandidate, leftCandidate, r.TorrentInfo.PieceSize)
 
 	r.addAnnounce(d, u, l, (float32(d)/float32(r.TorrentInfo.TotalSize))*100)
+	if lastAnnounce.Left > 0 && l <= 0 {
+		r.Status = eventCompleted
+	}
+	if r.session != nil {
+		r.session.updateDownloading(r, l)
+	}
+}
+
+// speeds returns the download and upload speed of the torrent, inside a session it is the torrent share of the session budget
+func (r *RatioSpoof) speeds() (download, upload int) {
+	if r.session != nil {
+		return r.session.share(r)
+	}
+	return r.Input.DownloadSpeed, r.Input.UploadSpeed
 }
 
 func calculateNextTotalSizeByte(speedBytePerSecond, currentByte, pieceSizeByte, seconds, limitTotalBytes, randomPieces int) int {
-	if speedBytePerSecond == 0 {
+	if speedBytePerSecond == 0 || seconds <= 0 {
 		return currentByte
 	}
 	totalCandidate := currentByte + (speedBytePerSecond * seconds)
@@ -1,7 +1,22 @@
 package ratiospoof
 
 import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/ap-pauloafonso/ratio-spoof/bencode"
+	"github.com/ap-pauloafonso/ratio-spoof/clock"
+	"github.com/ap-pauloafonso/ratio-spoof/emulation"
+	"github.com/ap-pauloafonso/ratio-spoof/generator"
+	"github.com/ap-pauloafonso/ratio-spoof/input"
+	"github.com/ap-pauloafonso/ratio-spoof/tracker"
 )
 
 func TestCalculateNextTotalSizeByte(t *testing.T) {
@@ -13,3 +28,295 @@ func TestCalculateNextTotalSizeByte(t *testing.T) {
 		t.Errorf("\ngot : %v\nwant: %v", got, want)
 	}
 }
+
+func TestShortLastPiece(t *testing.T) {
+	r := newTestRatioSpoof(t, &fakeTracker{response: tracker.TrackerResponse{Interval: 1800}})
+	r.TorrentInfo.TotalSize = 100*r.TorrentInfo.PieceSize + 1000
+	r.Input.InitialDownloaded = 50*r.TorrentInfo.PieceSize + 20000
+	r.BitTorrentClient.RoundingGenerator, _ = generator.NewPieceRoundingGenerator()
+	// a few pieces per announce so the download completes along the loop
+	r.Input.DownloadSpeed = 50
+	r.AnnounceInterval = 1800
+	if err := r.firstAnnounce(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 20; i++ {
+		entry := r.AnnounceHistory.Back().(AnnounceEntry)
+		if entry.Downloaded+entry.Left != r.TorrentInfo.TotalSize {
+			t.Errorf("[%v]downloaded %v + left %v got %v want %v", entry.Count, entry.Downloaded, entry.Left, entry.Downloaded+entry.Left, r.TorrentInfo.TotalSize)
+		}
+		if entry.Downloaded%r.TorrentInfo.PieceSize != 0 && entry.Downloaded != r.TorrentInfo.TotalSize {
+			t.Errorf("[%v]got %v downloaded want whole pieces", entry.Count, entry.Downloaded)
+		}
+		r.generateNextAnnounce()
+	}
+	if last := r.AnnounceHistory.Back().(AnnounceEntry); last.Left != 0 {
+		t.Errorf("got: %v left want 0", last.Left)
+	}
+}
+
+func TestFirstAnnounceRounding(t *testing.T) {
+	pieceSize := 256 * 1024
+	initial := 10*pieceSize + 20000
+	data := []struct {
+		generator string
+		expected  int
+	}{
+		{generator: generator.PieceRoundingGeneratorName, expected: 10 * pieceSize},
+		{generator: generator.BlockRoundingGeneratorName, expected: 10*pieceSize + 16*1024},
+		{generator: generator.NoRoundingGeneratorName, expected: initial},
+	}
+	for _, td := range data {
+		t.Run(td.generator, func(t *testing.T) {
+			r := newTestRatioSpoof(t, &fakeTracker{response: tracker.TrackerResponse{Interval: 1800}})
+			r.TorrentInfo.PieceSize = pieceSize
+			r.TorrentInfo.TotalSize = 100 * pieceSize
+			r.Input.InitialDownloaded = initial
+			rounding, err := generator.NewRoundingGenerator(td.generator, "", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r.BitTorrentClient.RoundingGenerator = rounding
+			if err := r.firstAnnounce(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			if got := r.AnnounceHistory.Back().(AnnounceEntry); got.Downloaded != td.expected || got.Left != r.TorrentInfo.TotalSize-td.expected {
+				t.Errorf("got: %v, %v want %v, %v", got.Downloaded, got.Left, td.expected, r.TorrentInfo.TotalSize-td.expected)
+			}
+		})
+	}
+}
+
+// fakeTracker is an in-memory tracker.Tracker that records every announce query
+type fakeTracker struct {
+	mu       sync.Mutex
+	queries  []string
+	response tracker.TrackerResponse
+	err      error
+}
+
+func (f *fakeTracker) Announce(ctx context.Context, query string, headers map[string]string, retry bool) (*tracker.TrackerResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.queries = append(f.queries, query)
+	if f.err != nil {
+		return nil, f.err
+	}
+	resp := f.response
+	return &resp, nil
+}
+
+func (f *fakeTracker) Scrape(ctx context.Context, infoHash string, headers map[string]string) (*tracker.ScrapeResponse, error) {
+	return &tracker.ScrapeResponse{Seeders: f.response.Seeders, Leechers: f.response.Leechers}, nil
+}
+
+func (f *fakeTracker) Status() tracker.Status {
+	return tracker.Status{}
+}
+
+func newTestRatioSpoof(t *testing.T, fake *fakeTracker) *RatioSpoof {
+	t.Helper()
+	rng := rand.New(rand.NewSource(1))
+	client, err := emulation.NewEmulation("qbit-4.3.3", rng)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &RatioSpoof{
+		TorrentInfo:      &bencode.TorrentInfo{Name: "test", PieceSize: 16 * 1024, TotalSize: 100 * 16 * 1024, InfoHashURLEncoded: "%01%02"},
+		Input:            &input.InputParsed{Port: 8999, DownloadSpeed: 1024, UploadSpeed: 1024},
+		Tracker:          fake,
+		BitTorrentClient: client,
+		NumWant:          200,
+		Status:           eventStarted,
+		DownloadModel:    constantSpeed{},
+		UploadModel:      constantSpeed{},
+		clock:            clock.Real,
+		rng:              rng,
+	}
+}
+
+func TestFireAnnounce(t *testing.T) {
+	fake := &fakeTracker{response: tracker.TrackerResponse{Interval: 1800, Seeders: 10, Leechers: 3}}
+	r := newTestRatioSpoof(t, fake)
+	r.firstAnnounce(context.Background())
+
+	if len(fake.queries) != 1 {
+		t.Fatalf("got %v announces want 1", len(fake.queries))
+	}
+	query := fake.queries[0]
+	for _, want := range []string{"info_hash=%01%02", "port=8999", "uploaded=0", "downloaded=0", "left=1638400", "event=started", "numwant=200", "peer_id=" + r.BitTorrentClient.PeerId()} {
+		if !strings.Contains(query, want) {
+			t.Errorf("query %v should contain %v", query, want)
+		}
+	}
+	if r.Seeders != 10 || r.Leechers != 3 || r.AnnounceInterval != 1800 {
+		t.Errorf("got seeders %v leechers %v interval %v", r.Seeders, r.Leechers, r.AnnounceInterval)
+	}
+}
+
+func TestTrackerId(t *testing.T) {
+	fake := &fakeTracker{response: tracker.TrackerResponse{Interval: 1800}}
+	r := newTestRatioSpoof(t, fake)
+	r.firstAnnounce(context.Background())
+
+	fake.response.TrackerId = "id 1"
+	r.fireAnnounce(context.Background(), false)
+	fake.response.TrackerId = ""
+	r.fireAnnounce(context.Background(), false)
+	r.fireAnnounce(context.Background(), false)
+
+	if strings.Contains(fake.queries[0], "trackerid") || strings.Contains(fake.queries[1], "trackerid") {
+		t.Errorf("trackerid should only be sent after the tracker returns one")
+	}
+	for _, query := range fake.queries[2:] {
+		if !strings.HasSuffix(query, "&trackerid=id+1") {
+			t.Errorf("query %v should echo the last tracker id", query)
+		}
+	}
+}
+
+func TestRunCancel(t *testing.T) {
+	fake := &fakeTracker{response: tracker.TrackerResponse{Interval: 1800}}
+	r := newTestRatioSpoof(t, fake)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if err := r.Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Errorf("Run should return as soon as the context is done")
+	}
+	if len(fake.queries) != 2 {
+		t.Fatalf("got %v announces want 2", len(fake.queries))
+	}
+	if !strings.Contains(fake.queries[1], "event=stopped") || !strings.Contains(fake.queries[1], "numwant=0") {
+		t.Errorf("last announce should be the stopped event, got %v", fake.queries[1])
+	}
+}
+
+func TestFireAnnounceErrors(t *testing.T) {
+	t.Run("Tracker failure is returned wrapped", func(t *testing.T) {
+		fake := &fakeTracker{err: &tracker.TrackerError{Reason: "unregistered torrent"}}
+		r := newTestRatioSpoof(t, fake)
+		err := r.firstAnnounce(context.Background())
+		var trackerErr *tracker.TrackerError
+		if !errors.As(err, &trackerErr) || trackerErr.Reason != "unregistered torrent" {
+			t.Errorf("got: %v want a tracker error", err)
+		}
+	})
+	t.Run("Unreachable tracker is returned wrapped", func(t *testing.T) {
+		fake := &fakeTracker{err: tracker.ErrTrackerUnreachable}
+		r := newTestRatioSpoof(t, fake)
+		if err := r.Run(context.Background()); !errors.Is(err, tracker.ErrTrackerUnreachable) {
+			t.Errorf("got: %v want %v", err, tracker.ErrTrackerUnreachable)
+		}
+	})
+}
+
+func TestRunStopConditions(t *testing.T) {
+	fake := &fakeTracker{response: tracker.TrackerResponse{Interval: 1}}
+	r := newTestRatioSpoof(t, fake)
+	r.clock = clock.NewSimulated(time.Now())
+	r.Input.StopUploaded = 1
+
+	err := r.Run(context.Background())
+	if !errors.Is(err, ErrStopConditionReached) {
+		t.Fatalf("got: %v want %v", err, ErrStopConditionReached)
+	}
+	if len(fake.queries) != 3 || !strings.Contains(fake.queries[2], "event=stopped") {
+		t.Errorf("got: %v want started, regular and stopped announces", fake.queries)
+	}
+
+	fake = &fakeTracker{response: tracker.TrackerResponse{Interval: 1800}}
+	r = newTestRatioSpoof(t, fake)
+	r.clock = clock.NewSimulated(time.Now())
+	r.Input.StopAfter = 50 * time.Millisecond
+	err = r.Run(context.Background())
+	if !errors.Is(err, ErrStopConditionReached) {
+		t.Fatalf("got: %v want %v", err, ErrStopConditionReached)
+	}
+	if len(fake.queries) != 2 || !strings.Contains(fake.queries[1], "event=stopped") {
+		t.Errorf("got: %v want started and stopped announces", fake.queries)
+	}
+
+	// the stopped announce only reports what was uploaded until the time limit, not a whole interval
+	fake = &fakeTracker{response: tracker.TrackerResponse{Interval: 1800}}
+	r = newTestRatioSpoof(t, fake)
+	r.clock = clock.NewSimulated(time.Now())
+	r.Input.StopAfter = 10 * time.Minute
+	err = r.Run(context.Background())
+	if !errors.Is(err, ErrStopConditionReached) {
+		t.Fatalf("got: %v want %v", err, ErrStopConditionReached)
+	}
+	if len(fake.queries) != 2 || !strings.Contains(fake.queries[1], "event=stopped") {
+		t.Fatalf("got: %v want started and stopped announces", fake.queries)
+	}
+	values, _ := url.ParseQuery(fake.queries[1])
+	uploaded, _ := strconv.Atoi(values.Get("uploaded"))
+	// 10 minutes at the upload speed plus at most 9 random pieces
+	if limit := 600*r.Input.UploadSpeed + 9*r.TorrentInfo.PieceSize; uploaded <= 0 || uploaded > limit {
+		t.Errorf("got: %v uploaded want at most %v", uploaded, limit)
+	}
+}
+
+func TestStopReason(t *testing.T) {
+	r := newTestRatioSpoof(t, &fakeTracker{})
+	r.Input.StopRatio = 1.5
+	r.addAnnounce(0, r.TorrentInfo.TotalSize, 0, 100)
+	if got := r.stopReason(); got != "" {
+		t.Errorf("got: %v want no reason", got)
+	}
+	r.addAnnounce(0, r.TorrentInfo.TotalSize*3/2, 0, 100)
+	if got := r.stopReason(); got != "ratio 1.5" {
+		t.Errorf("got: %v want %v", got, "ratio 1.5")
+	}
+}
+
+func TestAnnounceEvents(t *testing.T) {
+	fake := &fakeTracker{response: tracker.TrackerResponse{Interval: 1}}
+	r := newTestRatioSpoof(t, fake)
+	if err := r.firstAnnounce(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	for _, downloadSpeed := range []int{1024, r.TorrentInfo.TotalSize, 1024} {
+		r.Input.DownloadSpeed = downloadSpeed
+		r.generateNextAnnounce()
+		if err := r.fireAnnounce(context.Background(), false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := r.gracefullyExit(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"started", "", "completed", "", "stopped"}
+	if len(fake.queries) != len(want) {
+		t.Fatalf("got %v announces want %v", len(fake.queries), len(want))
+	}
+	for i, event := range want {
+		query := fake.queries[i]
+		if event == "" && strings.Contains(query, "event=") {
+			t.Errorf("[%v]query %v should not send an event", i, query)
+		}
+		if event != "" && !strings.Contains(query, "&event="+event+"&") {
+			t.Errorf("[%v]query %v should contain the %v event", i, query, event)
+		}
+	}
+}
+
+func TestAnnounceEventsStartingComplete(t *testing.T) {
+	fake := &fakeTracker{response: tracker.TrackerResponse{Interval: 1}}
+	r := newTestRatioSpoof(t, fake)
+	r.Input.InitialDownloaded = r.TorrentInfo.TotalSize
+	r.firstAnnounce(context.Background())
+	r.generateNextAnnounce()
+	r.fireAnnounce(context.Background(), false)
+
+	for _, query := range fake.queries {
+		if strings.Contains(query, "event=completed") {
+			t.Errorf("a torrent already complete should never send completed, got %v", query)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package ratiospoof
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/ap-pauloafonso/ratio-spoof/clock"
+	"github.com/ap-pauloafonso/ratio-spoof/emulation"
+	"github.com/ap-pauloafonso/ratio-spoof/input"
+	"math/rand"
+	"sync"
+)
+
+// Session announces several torrents as a single client instance: every torrent shares the same
+// emulated client, peer id, key and port, is scheduled independently and gets a share of the session download/upload budget
+type Session struct {
+	BitTorrentClient *emulation.Emulation
+	Torrents         []*RatioSpoof
+	DownloadSpeed    int
+	UploadSpeed      int
+
+	mu     sync.Mutex
+	states map[*RatioSpoof]torrentState
+}
+
+type torrentState int
+
+const (
+	torrentDownloading torrentState = iota
+	torrentSeeding
+	torrentStopped
+)
+
+// NewSession loads every torrent path with the same input, the speeds of the input are the budget of the whole session
+func NewSession(args input.InputArgs, torrentPaths []string) (*Session, error) {
+	return newSession(args, torrentPaths, clock.Real, newRand())
+}
+
+// newSession builds the session on the given clock, every random choice of the session and its torrents comes from rng
+func newSession(args input.InputArgs, torrentPaths []string, clk clock.Clock, rng *rand.Rand) (*Session, error) {
+	if len(torrentPaths) == 0 {
+		return nil, errors.New("a session needs at least one torrent")
+	}
+	client, err := emulation.Profiles{Dir: args.ProfilesDir}.NewEmulation(args.Client, rng)
+	if err != nil {
+		return nil, fmt.Errorf("error building the emulated client with the code %v: %w", args.Client, err)
+	}
+
+	s := &Session{BitTorrentClient: client}
+	for _, path := range torrentPaths {
+		torrentArgs := args
+		torrentArgs.TorrentPath = path
+		// every torrent runs on its own goroutine so it gets its own random source
+		torrentRng := rand.New(rand.NewSource(rng.Int63()))
+		r, err := newRatioSpoof(torrentArgs, client.Shared(), clk, torrentRng)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %w", path, err)
+		}
+		s.DownloadSpeed, s.UploadSpeed = r.Input.DownloadSpeed, r.Input.UploadSpeed
+		s.add(r)
+	}
+	return s, nil
+}
+
+func (s *Session) add(r *RatioSpoof) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.states == nil {
+		s.states = make(map[*RatioSpoof]torrentState)
+	}
+	r.session = s
+	s.Torrents = append(s.Torrents, r)
+	s.states[r] = torrentSeeding
+	if r.Input.InitialDownloaded < r.TorrentInfo.TotalSize {
+		s.states[r] = torrentDownloading
+	}
+}
+
+// share splits the download budget between the torrents still downloading and the upload budget between every running torrent
+func (s *Session) share(r *RatioSpoof) (download, upload int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var downloadingCount, runningCount int
+	for _, state := range s.states {
+		if state == torrentDownloading {
+			downloadingCount++
+		}
+		if state != torrentStopped {
+			runningCount++
+		}
+	}
+	if s.states[r] == torrentDownloading {
+		download = s.DownloadSpeed / downloadingCount
+	}
+	if s.states[r] != torrentStopped {
+		upload = s.UploadSpeed / runningCount
+	}
+	return download, upload
+}
+
+// updateDownloading releases the torrent download share once it has nothing left
+func (s *Session) updateDownloading(r *RatioSpoof, left int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.states[r] != torrentStopped && left <= 0 {
+		s.states[r] = torrentSeeding
+	}
+}
+
+// stop releases every share of a torrent that is not running anymore
+func (s *Session) stop(r *RatioSpoof) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.states[r] = torrentStopped
+}
+
+// Run runs every torrent until the context is done, a torrent failing does not stop the others.
+// The failures are returned when any torrent failed, the error only wraps ErrStopConditionReached when every torrent
+// stopped on its stop condition
+func (s *Session) Run(ctx context.Context) error {
+	var wg sync.WaitGroup
+	errs := make([]error, len(s.Torrents))
+	for i, r := range s.Torrents {
+		wg.Add(1)
+		go func(i int, r *RatioSpoof) {
+			defer wg.Done()
+			defer s.stop(r)
+			if err := r.Run(ctx); err != nil {
+				errs[i] = fmt.Errorf("%v: %w", r.TorrentInfo.Name, err)
+			}
+		}(i, r)
+	}
+	wg.Wait()
+
+	var failures []error
+	var stopped int
+	for _, err := range errs {
+		switch {
+		case errors.Is(err, ErrStopConditionReached):
+			stopped++
+		case err != nil:
+			failures = append(failures, err)
+		}
+	}
+	if len(failures) > 0 {
+		return errors.Join(failures...)
+	}
+	if stopped == len(s.Torrents) {
+		return errors.Join(errs...)
+	}
+	return nil
+}
@@ -0,0 +1,186 @@
+package ratiospoof
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ap-pauloafonso/ratio-spoof/clock"
+	"github.com/ap-pauloafonso/ratio-spoof/emulation"
+	"github.com/ap-pauloafonso/ratio-spoof/input"
+	"github.com/ap-pauloafonso/ratio-spoof/tracker"
+)
+
+// newTestSession builds a session of the args torrent announcing once per fake tracker
+func newTestSession(t *testing.T, args input.InputArgs, fakes ...*fakeTracker) *Session {
+	t.Helper()
+	paths := make([]string, len(fakes))
+	for i := range paths {
+		paths[i] = args.TorrentPath
+	}
+	s, err := newSession(args, paths, clock.Real, rand.New(rand.NewSource(1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, fake := range fakes {
+		s.Torrents[i].Tracker = fake
+		s.Torrents[i].Print = false
+	}
+	return s
+}
+
+func TestSessionShare(t *testing.T) {
+	s := newTestSession(t, testSimulationArgs(), &fakeTracker{}, &fakeTracker{}, &fakeTracker{})
+	s.DownloadSpeed, s.UploadSpeed = 1024, 3072
+	s.updateDownloading(s.Torrents[0], 0)
+	download, upload := s.share(s.Torrents[0])
+	if download != 0 || upload != 1024 {
+		t.Errorf("got: %v/%v want %v/%v", download, upload, 0, 1024)
+	}
+	download, upload = s.share(s.Torrents[1])
+	if download != 512 || upload != 1024 {
+		t.Errorf("got: %v/%v want %v/%v", download, upload, 512, 1024)
+	}
+
+	s.stop(s.Torrents[1])
+	download, upload = s.share(s.Torrents[2])
+	if download != 1024 || upload != 1536 {
+		t.Errorf("got: %v/%v want %v/%v", download, upload, 1024, 1536)
+	}
+}
+
+func TestSessionRun(t *testing.T) {
+	fakes := []*fakeTracker{
+		{response: tracker.TrackerResponse{Interval: 1}},
+		{response: tracker.TrackerResponse{Interval: 1}},
+	}
+	s := newTestSession(t, testSimulationArgs(), fakes...)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := s.Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, fake := range fakes {
+		peerId := "peer_id=" + s.BitTorrentClient.PeerId()
+		if len(fake.queries) != 2 {
+			t.Fatalf("torrent %v: got %v announces want 2", i, len(fake.queries))
+		}
+		for _, query := range fake.queries {
+			if !strings.Contains(query, peerId) {
+				t.Errorf("torrent %v: query %v should contain %v", i, query, peerId)
+			}
+		}
+		if !strings.Contains(fake.queries[1], "event=stopped") {
+			t.Errorf("torrent %v: got %v want a stopped announce", i, fake.queries[1])
+		}
+	}
+}
+
+func TestSessionRunErrors(t *testing.T) {
+	data := []struct {
+		name      string
+		stops     []bool
+		fail      bool
+		stopped   bool
+		wantError bool
+	}{
+		{name: "every torrent stopped", stops: []bool{true, true}, stopped: true, wantError: true},
+		{name: "a torrent failed", stops: []bool{true, false}, fail: true, wantError: true},
+		{name: "a torrent ran until the end", stops: []bool{true, false}},
+	}
+	for _, td := range data {
+		t.Run(td.name, func(t *testing.T) {
+			args := testSimulationArgs()
+			args.InitialUploaded = "100%"
+			fakes := []*fakeTracker{{response: tracker.TrackerResponse{Interval: 1800}}, {response: tracker.TrackerResponse{Interval: 1800}}}
+			if td.fail {
+				fakes[1].err = errors.New("connection refused")
+			}
+			s := newTestSession(t, args, fakes...)
+			for i, stop := range td.stops {
+				if stop {
+					s.Torrents[i].Input.StopRatio = 1
+				}
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			err := s.Run(ctx)
+			if (err != nil) != td.wantError {
+				t.Fatalf("got: %v want an error %v", err, td.wantError)
+			}
+			if stopped := errors.Is(err, ErrStopConditionReached); stopped != td.stopped {
+				t.Errorf("got: %v, stop condition reached got: %v want %v", err, stopped, td.stopped)
+			}
+		})
+	}
+}
+
+func TestSessionRefreshPolicies(t *testing.T) {
+	// a session has a single identity whatever the refresh policies of the profile
+	data := []emulation.RefreshPolicy{emulation.RefreshProcess, emulation.RefreshTorrent, emulation.RefreshRestart, emulation.RefreshPersisted}
+	for _, policy := range data {
+		t.Run(string(policy), func(t *testing.T) {
+			dir := t.TempDir()
+			writeTestProfile(t, dir, func(c *emulation.ClientInfo) {
+				c.PeerID.Refresh, c.Key.Refresh = policy, policy
+			})
+			args := testSimulationArgs()
+			args.ProfilesDir = dir
+			args.Client = "custom-1.0"
+			fakes := []*fakeTracker{{response: tracker.TrackerResponse{Interval: 1800}}, {response: tracker.TrackerResponse{Interval: 1800}}}
+			s := newTestSession(t, args, fakes...)
+
+			// the run is already over, every torrent sends its started announce and the stopped one
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			if err := s.Run(ctx); err != nil {
+				t.Fatal(err)
+			}
+
+			var peerIds, keys []string
+			for i, fake := range fakes {
+				values, err := url.ParseQuery(fake.queries[0])
+				if err != nil {
+					t.Fatal(err)
+				}
+				if values.Get("event") != eventStarted {
+					t.Fatalf("torrent %v: got %v want a started announce", i, fake.queries[0])
+				}
+				peerIds = append(peerIds, values.Get("peer_id"))
+				keys = append(keys, values.Get("key"))
+			}
+			for i := range fakes {
+				if peerIds[i] != s.BitTorrentClient.PeerId() || keys[i] != s.BitTorrentClient.Key() {
+					t.Errorf("torrent %v: got %v %v want the session client %v %v", i, peerIds[i], keys[i], s.BitTorrentClient.PeerId(), s.BitTorrentClient.Key())
+				}
+			}
+		})
+	}
+}
+
+// writeTestProfile writes the custom-1.0 profile in dir, override changes it before it is written
+func writeTestProfile(t *testing.T, dir string, override func(c *emulation.ClientInfo)) {
+	t.Helper()
+	c := emulation.ClientInfo{Name: "custom", Query: "info_hash={infohash}&peer_id={peerid}&port={port}&uploaded={uploaded}&downloaded={downloaded}&left={left}&key={key}{event}&numwant={numwant}"}
+	c.PeerID.Regex = "-XX0100-[a-z]{12}"
+	c.Key.Generator = "defaultKeyGenerator"
+	c.Rounding.Generator = "pieceRoundingGenerator"
+	override(&c)
+	dat, err := json.Marshal(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "custom-1.0.json"), dat, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,121 @@
+package ratiospoof
+
+import (
+	"context"
+	"fmt"
+	"github.com/ap-pauloafonso/ratio-spoof/clock"
+	"github.com/ap-pauloafonso/ratio-spoof/emulation"
+	"github.com/ap-pauloafonso/ratio-spoof/input"
+	"github.com/ap-pauloafonso/ratio-spoof/tracker"
+	"math/rand"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const maxDryRunAnnounces = 20
+
+// Simulation describes a run fast-forwarded on a simulated clock against a simulated tracker
+type Simulation struct {
+	// Announces is how many announces are made before the stopped one
+	Announces int
+	Seed      int64
+	Start     time.Time
+	Response  tracker.TrackerResponse
+}
+
+// SimulatedAnnounce is an announce as the simulated tracker received it
+type SimulatedAnnounce struct {
+	Time       time.Time
+	Event      string
+	Downloaded int
+	Uploaded   int
+	Left       int
+	Url        string
+	Headers    map[string]string
+}
+
+// Simulate runs the torrent of the input like Run does without waiting nor touching the network, the same input,
+// seed and start always produce the same announces. Stop conditions apply, the state is neither loaded nor saved
+func Simulate(args input.InputArgs, sim Simulation) ([]SimulatedAnnounce, error) {
+	if sim.Announces < 1 {
+		return nil, fmt.Errorf("a simulation needs at least one announce")
+	}
+	args.StateDir = ""
+	return simulate(args, rand.New(rand.NewSource(sim.Seed)), clock.NewSimulated(sim.Start), sim.Response, func(announces []SimulatedAnnounce) bool {
+		return len(announces) == sim.Announces
+	})
+}
+
+// DryRun renders the announces of the torrent lifecycle without sending any of them: started, the regular ones until the
+// download completes, completed, one more regular announce and stopped, with at most maxDryRunAnnounces before stopped.
+// The saved state is loaded, so the announces are the ones the next run would send, but it is never saved
+func DryRun(args input.InputArgs) ([]SimulatedAnnounce, error) {
+	response := tracker.TrackerResponse{Interval: 1800, Seeders: 10, Leechers: 10}
+	return simulate(args, newRand(), clock.NewSimulated(time.Now()), response, func(announces []SimulatedAnnounce) bool {
+		last := announces[len(announces)-1]
+		return len(announces) == maxDryRunAnnounces || (last.Event == eventNone && last.Left == 0)
+	})
+}
+
+// simulate runs the torrent against a simulated tracker answering response until done returns true
+func simulate(args input.InputArgs, rng *rand.Rand, clk *clock.Simulated, response tracker.TrackerResponse, done func(announces []SimulatedAnnounce) bool) ([]SimulatedAnnounce, error) {
+	client, err := emulation.Profiles{Dir: args.ProfilesDir}.NewEmulation(args.Client, rng)
+	if err != nil {
+		return nil, fmt.Errorf("error building the emulated client with the code %v: %w", args.Client, err)
+	}
+	r, err := newRatioSpoof(args, client, clk, rng)
+	if err != nil {
+		return nil, err
+	}
+	r.Print = false
+	r.Input.StateDir = ""
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	simulated := &simulatedTracker{response: response, clock: clk, url: r.Tracker.Status().Url, done: done, cancel: cancel}
+	if simulated.response.Interval <= 0 {
+		simulated.response.Interval = 1800
+	}
+	r.Tracker = simulated
+	err = r.Run(ctx)
+	return simulated.announces, err
+}
+
+// simulatedTracker answers every announce with the same response and cancels the run once done says so
+type simulatedTracker struct {
+	response  tracker.TrackerResponse
+	clock     clock.Clock
+	url       string
+	announces []SimulatedAnnounce
+	done      func(announces []SimulatedAnnounce) bool
+	cancel    func()
+	status    tracker.Status
+}
+
+func (s *simulatedTracker) Announce(ctx context.Context, query string, headers map[string]string, retry bool) (*tracker.TrackerResponse, error) {
+	values, err := url.ParseQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	fullUrl := tracker.BuildFullUrl(s.url, query)
+	announce := SimulatedAnnounce{Time: s.clock.Now(), Event: values.Get("event"), Url: fullUrl, Headers: headers}
+	announce.Downloaded, _ = strconv.Atoi(values.Get("downloaded"))
+	announce.Uploaded, _ = strconv.Atoi(values.Get("uploaded"))
+	announce.Left, _ = strconv.Atoi(values.Get("left"))
+	s.announces = append(s.announces, announce)
+
+	if announce.Event != eventStopped && s.done(s.announces) {
+		s.cancel()
+	}
+	s.status = tracker.Status{Url: s.url, LastAnounceRequest: fullUrl, EstimatedTimeToAnnounce: announce.Time.Add(time.Duration(s.response.Interval) * time.Second)}
+	resp := s.response
+	return &resp, nil
+}
+func (s *simulatedTracker) Scrape(ctx context.Context, infoHash string, headers map[string]string) (*tracker.ScrapeResponse, error) {
+	return &tracker.ScrapeResponse{Seeders: s.response.Seeders, Leechers: s.response.Leechers}, nil
+}
+
+func (s *simulatedTracker) Status() tracker.Status {
+	return s.status
+}
@@ -0,0 +1,201 @@
+package ratiospoof
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ap-pauloafonso/ratio-spoof/emulation"
+	"github.com/ap-pauloafonso/ratio-spoof/input"
+	"github.com/ap-pauloafonso/ratio-spoof/tracker"
+)
+
+func testSimulationArgs() input.InputArgs {
+	return input.InputArgs{
+		TorrentPath:       "../bencode/torrent_files_test/debian-12.0.0-amd64-DVD-1.iso.torrent",
+		InitialDownloaded: "90%",
+		DownloadSpeed:     "10mbps",
+		InitialUploaded:   "0%",
+		UploadSpeed:       "1mbps",
+		Port:              8999,
+		Client:            "qbit-4.3.3",
+		SpeedModel:        "gaussian",
+	}
+}
+
+func TestSimulate(t *testing.T) {
+	start := time.Date(2021, 5, 10, 12, 0, 0, 0, time.UTC)
+	sim := Simulation{Announces: 5, Seed: 42, Start: start, Response: tracker.TrackerResponse{Interval: 1800}}
+	got, err := Simulate(testSimulationArgs(), sim)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got) != 6 {
+		t.Fatalf("got %v announces want 6", len(got))
+	}
+	for i, announce := range got[:5] {
+		if want := start.Add(time.Duration(i) * 30 * time.Minute); !announce.Time.Equal(want) {
+			t.Errorf("[%v]got: %v want %v", i, announce.Time, want)
+		}
+	}
+	if got[0].Event != "started" || got[5].Event != "stopped" {
+		t.Errorf("got: %v want started first and stopped last", got)
+	}
+
+	again, _ := Simulate(testSimulationArgs(), sim)
+	if !reflect.DeepEqual(got, again) {
+		t.Errorf("the same seed should give the same announces\ngot : %v\nwant: %v", again, got)
+	}
+	sim.Seed = 43
+	other, _ := Simulate(testSimulationArgs(), sim)
+	if reflect.DeepEqual(got, other) {
+		t.Errorf("another seed should give other announces, got %v", other)
+	}
+}
+
+func TestSimulateStopCondition(t *testing.T) {
+	args := testSimulationArgs()
+	args.StopAfter = 24 * time.Hour
+	sim := Simulation{Announces: 1000, Seed: 1, Start: time.Date(2021, 5, 10, 12, 0, 0, 0, time.UTC), Response: tracker.TrackerResponse{Interval: 1800}}
+	got, err := Simulate(args, sim)
+	if !errors.Is(err, ErrStopConditionReached) {
+		t.Fatalf("got: %v want %v", err, ErrStopConditionReached)
+	}
+	last := got[len(got)-1]
+	if want := sim.Start.Add(24 * time.Hour); last.Event != "stopped" || !last.Time.Equal(want) {
+		t.Errorf("got: %v want a stopped announce at %v", last, want)
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	args := testSimulationArgs()
+	args.DownloadSpeed = "100kbps"
+	got, err := DryRun(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var events []string
+	for _, announce := range got {
+		if len(events) == 0 || events[len(events)-1] != announce.Event {
+			events = append(events, announce.Event)
+		}
+		if !strings.HasPrefix(announce.Url, "http://bttracker.debian.org:6969/announce?info_hash=") {
+			t.Errorf("got: %v want the full announce url", announce.Url)
+		}
+		if announce.Headers["User-Agent"] != "qBittorrent/4.3.3" {
+			t.Errorf("got: %v want the emulation headers", announce.Headers)
+		}
+	}
+	want := []string{"started", "", "completed", "", "stopped"}
+	if !reflect.DeepEqual(events, want) {
+		t.Errorf("got: %q want %q", events, want)
+	}
+}
+
+func TestSimulateStopAtFromStart(t *testing.T) {
+	args := testSimulationArgs()
+	args.StopAt = "13:00"
+	start := time.Date(2021, 5, 10, 12, 0, 0, 0, time.UTC)
+	got, err := Simulate(args, Simulation{Announces: 10, Seed: 1, Start: start, Response: tracker.TrackerResponse{Interval: 1800}})
+	if !errors.Is(err, ErrStopConditionReached) {
+		t.Fatalf("got: %v want %v", err, ErrStopConditionReached)
+	}
+	// the stop time is the next 13:00 of the simulated clock, not of the wall clock
+	last := got[len(got)-1]
+	if want := start.Add(time.Hour); last.Event != eventStopped || !last.Time.Equal(want) {
+		t.Errorf("got: %v at %v want %v at %v", last.Event, last.Time, eventStopped, want)
+	}
+}
+
+// TestProfileAnnounceUrls pins the started and stopped announces of every embedded profile, a profile change shows up here
+func TestProfileAnnounceUrls(t *testing.T) {
+	data := []struct {
+		code      string
+		query     string
+		stopped   string
+		userAgent string
+	}{
+		{
+			code:      "bittorrent-7.10.5",
+			query:     "info_hash=%b1h%0aU%cf%c8i%3cl%02%des-%d1%7c3%e2Q%e8%e5&peer_id=-BT7a5W-9uJd9plox1AO&port=8999&uploaded=0&downloaded=3537895424&left=393199616&corrupt=0&key=F0C5341E&event=started&numwant=200&compact=1&no_peer_id=1",
+			stopped:   "info_hash=%b1h%0aU%cf%c8i%3cl%02%des-%d1%7c3%e2Q%e8%e5&peer_id=-BT7a5W-9uJd9plox1AO&port=8999&uploaded=0&downloaded=3537895424&left=393199616&corrupt=0&key=F0C5341E&event=stopped&numwant=0&compact=1&no_peer_id=1",
+			userAgent: "BitTorrent/7a5(46206)",
+		},
+		{
+			code:      "deluge-2.1.1",
+			query:     "info_hash=%b1h%0aU%cf%c8i%3cl%02%des-%d1%7c3%e2Q%e8%e5&peer_id=-DE211s-9uJd9plox1AO&port=8999&uploaded=0&downloaded=3537895424&left=393199616&corrupt=0&key=4F163F5F&event=started&numwant=200&compact=1&no_peer_id=1&supportcrypto=1&redundant=0",
+			stopped:   "info_hash=%b1h%0aU%cf%c8i%3cl%02%des-%d1%7c3%e2Q%e8%e5&peer_id=-DE211s-9uJd9plox1AO&port=8999&uploaded=0&downloaded=3537895424&left=393199616&corrupt=0&key=4F163F5F&event=stopped&numwant=0&compact=1&no_peer_id=1&supportcrypto=1&redundant=0",
+			userAgent: "Deluge/2.1.1 libtorrent/2.0.7.0",
+		},
+		{
+			code:      "qbit-4.0.3",
+			query:     "info_hash=%b1h%0aU%cf%c8i%3cl%02%des-%d1%7c3%e2Q%e8%e5&peer_id=-qB4030-9uJd9plox1AO&port=8999&uploaded=0&downloaded=3537895424&left=393199616&corrupt=0&key=4F163F5F&event=started&numwant=200&compact=1&no_peer_id=1&supportcrypto=1&redundant=0",
+			stopped:   "info_hash=%b1h%0aU%cf%c8i%3cl%02%des-%d1%7c3%e2Q%e8%e5&peer_id=-qB4030-9uJd9plox1AO&port=8999&uploaded=0&downloaded=3537895424&left=393199616&corrupt=0&key=4F163F5F&event=stopped&numwant=0&compact=1&no_peer_id=1&supportcrypto=1&redundant=0",
+			userAgent: "qBittorrent/4.0.3",
+		},
+		{
+			code:      "qbit-4.3.3",
+			query:     "info_hash=%b1h%0aU%cf%c8i%3cl%02%des-%d1%7c3%e2Q%e8%e5&peer_id=-qB4330-9uJd9plox1AO&port=8999&uploaded=0&downloaded=3537895424&left=393199616&corrupt=0&key=4F163F5F&event=started&numwant=200&compact=1&no_peer_id=1&supportcrypto=1&redundant=0",
+			stopped:   "info_hash=%b1h%0aU%cf%c8i%3cl%02%des-%d1%7c3%e2Q%e8%e5&peer_id=-qB4330-9uJd9plox1AO&port=8999&uploaded=0&downloaded=3537895424&left=393199616&corrupt=0&key=4F163F5F&event=stopped&numwant=0&compact=1&no_peer_id=1&supportcrypto=1&redundant=0",
+			userAgent: "qBittorrent/4.3.3",
+		},
+		{
+			code:      "qbit-4.6.7",
+			query:     "info_hash=%b1h%0aU%cf%c8i%3cl%02%des-%d1%7c3%e2Q%e8%e5&peer_id=-qB4670-9uJd9plox1AO&port=8999&uploaded=0&downloaded=3537895424&left=393199616&corrupt=0&key=4F163F5F&event=started&numwant=200&compact=1&no_peer_id=1&supportcrypto=1&redundant=0",
+			stopped:   "info_hash=%b1h%0aU%cf%c8i%3cl%02%des-%d1%7c3%e2Q%e8%e5&peer_id=-qB4670-9uJd9plox1AO&port=8999&uploaded=0&downloaded=3537895424&left=393199616&corrupt=0&key=4F163F5F&event=stopped&numwant=0&compact=1&no_peer_id=1&supportcrypto=1&redundant=0",
+			userAgent: "qBittorrent/4.6.7",
+		},
+		{
+			code:      "qbit-5.0.4",
+			query:     "info_hash=%b1h%0aU%cf%c8i%3cl%02%des-%d1%7c3%e2Q%e8%e5&peer_id=-qB5040-9uJd9plox1AO&port=8999&uploaded=0&downloaded=3537895424&left=393199616&corrupt=0&key=4F163F5F&event=started&numwant=200&compact=1&no_peer_id=1&supportcrypto=1&redundant=0",
+			stopped:   "info_hash=%b1h%0aU%cf%c8i%3cl%02%des-%d1%7c3%e2Q%e8%e5&peer_id=-qB5040-9uJd9plox1AO&port=8999&uploaded=0&downloaded=3537895424&left=393199616&corrupt=0&key=4F163F5F&event=stopped&numwant=0&compact=1&no_peer_id=1&supportcrypto=1&redundant=0",
+			userAgent: "qBittorrent/5.0.4",
+		},
+		{
+			code:      "transmission-4.0.6",
+			query:     "info_hash=%b1h%0aU%cf%c8i%3cl%02%des-%d1%7c3%e2Q%e8%e5&peer_id=-TR4060-91t6fma3mlku&port=8999&uploaded=0&downloaded=3537977344&left=393117696&numwant=80&key=f0c5341e&compact=1&supportcrypto=1&event=started",
+			stopped:   "info_hash=%b1h%0aU%cf%c8i%3cl%02%des-%d1%7c3%e2Q%e8%e5&peer_id=-TR4060-91t6fma3mlku&port=8999&uploaded=0&downloaded=3537977344&left=393117696&numwant=0&key=f0c5341e&compact=1&supportcrypto=1&event=stopped",
+			userAgent: "Transmission/4.0.6",
+		},
+		{
+			code:      "utorrent-3.5.5",
+			query:     "info_hash=%b1h%0aU%cf%c8i%3cl%02%des-%d1%7c3%e2Q%e8%e5&peer_id=-UT355W-9uJd9plox1AO&port=8999&uploaded=0&downloaded=3537895424&left=393199616&corrupt=0&key=F0C5341E&event=started&numwant=200&compact=1&no_peer_id=1",
+			stopped:   "info_hash=%b1h%0aU%cf%c8i%3cl%02%des-%d1%7c3%e2Q%e8%e5&peer_id=-UT355W-9uJd9plox1AO&port=8999&uploaded=0&downloaded=3537895424&left=393199616&corrupt=0&key=F0C5341E&event=stopped&numwant=0&compact=1&no_peer_id=1",
+			userAgent: "uTorrent/355(46206)",
+		},
+	}
+
+	codes, err := emulation.Profiles{}.Codes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != len(data) {
+		t.Fatalf("got %v embedded profiles want %v, every profile needs its announce here", len(codes), len(data))
+	}
+	for _, td := range data {
+		t.Run(td.code, func(t *testing.T) {
+			args := testSimulationArgs()
+			args.Client = td.code
+			sim := Simulation{Announces: 1, Seed: 1, Start: time.Date(2021, 5, 10, 12, 0, 0, 0, time.UTC), Response: tracker.TrackerResponse{Interval: 1800}}
+			got, err := Simulate(args, sim)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != 2 || got[1].Event != eventStopped {
+				t.Fatalf("got %v announces want started and stopped", len(got))
+			}
+			if want := "http://bttracker.debian.org:6969/announce?" + td.query; got[0].Url != want {
+				t.Errorf("\ngot : %v\nwant: %v", got[0].Url, want)
+			}
+			if want := "http://bttracker.debian.org:6969/announce?" + td.stopped; got[1].Url != want {
+				t.Errorf("\ngot : %v\nwant: %v", got[1].Url, want)
+			}
+			if got[0].Headers["User-Agent"] != td.userAgent {
+				t.Errorf("got: %v want %v", got[0].Headers["User-Agent"], td.userAgent)
+			}
+		})
+	}
+}
@@ -0,0 +1,127 @@
+package ratiospoof
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"time"
+)
+
+// ErrUnknownSpeedModel is returned when there is no speed model with the given name
+var ErrUnknownSpeedModel = errors.New("unknown speed model")
+
+// DefaultSpeedModel is the speed model used when none is selected
+const DefaultSpeedModel = "constant"
+
+const (
+	uniformSpread     = 0.5
+	gaussianDeviation = 0.2
+	diurnalAmplitude  = 0.5
+	diurnalPeakHour   = 20
+	burstyDuty        = 0.5
+	burstyStay        = 0.75
+)
+
+// SpeedModel decides the speed of the next announce interval from the mean speed the user asked for
+type SpeedModel interface {
+	// Speed returns the bytes per second transferred during the interval starting at the given time
+	Speed(mean int, at time.Time) int
+}
+
+var speedModels = map[string]func(rng *rand.Rand) SpeedModel{
+	"constant": func(rng *rand.Rand) SpeedModel { return constantSpeed{} },
+	"uniform":  func(rng *rand.Rand) SpeedModel { return &uniformSpeed{rng: rng, spread: uniformSpread} },
+	"gaussian": func(rng *rand.Rand) SpeedModel { return &gaussianSpeed{rng: rng, deviation: gaussianDeviation} },
+	"diurnal": func(rng *rand.Rand) SpeedModel {
+		return diurnalSpeed{amplitude: diurnalAmplitude, peakHour: diurnalPeakHour}
+	},
+	"bursty": func(rng *rand.Rand) SpeedModel { return &burstySpeed{rng: rng, duty: burstyDuty, stay: burstyStay} },
+}
+
+// NewSpeedModel builds the speed model with the given name, every model keeps its own state
+func NewSpeedModel(name string, rng *rand.Rand) (SpeedModel, error) {
+	newModel, ok := speedModels[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %v", ErrUnknownSpeedModel, name)
+	}
+	return newModel(rng), nil
+}
+
+// SpeedModels returns the names of every speed model
+func SpeedModels() []string {
+	names := make([]string, 0, len(speedModels))
+	for name := range speedModels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// constantSpeed always transfers at the mean speed
+type constantSpeed struct{}
+
+func (constantSpeed) Speed(mean int, at time.Time) int {
+	return mean
+}
+
+// uniformSpeed picks a speed uniformly within spread of the mean
+type uniformSpeed struct {
+	rng    *rand.Rand
+	spread float64
+}
+
+func (u *uniformSpeed) Speed(mean int, at time.Time) int {
+	factor := 1 - u.spread + 2*u.spread*u.rng.Float64()
+	return int(float64(mean) * factor)
+}
+
+// gaussianSpeed jitters around the mean with a standard deviation relative to it
+type gaussianSpeed struct {
+	rng       *rand.Rand
+	deviation float64
+}
+
+func (g *gaussianSpeed) Speed(mean int, at time.Time) int {
+	speed := float64(mean) * (1 + g.deviation*g.rng.NormFloat64())
+	if speed < 0 {
+		return 0
+	}
+	return int(speed)
+}
+
+// diurnalSpeed follows a daily sine curve peaking at peakHour, its average over a day is the mean
+type diurnalSpeed struct {
+	amplitude float64
+	peakHour  float64
+}
+
+func (d diurnalSpeed) Speed(mean int, at time.Time) int {
+	hour := float64(at.Hour()) + float64(at.Minute())/60
+	return int(float64(mean) * (1 + d.amplitude*math.Cos(2*math.Pi*(hour-d.peakHour)/24)))
+}
+
+// burstySpeed alternates between transferring and idle intervals, bursts run at mean/duty so the long run average is the mean
+type burstySpeed struct {
+	rng  *rand.Rand
+	duty float64
+	// stay is the probability of a burst going on for one more interval
+	stay   float64
+	active bool
+}
+
+func (b *burstySpeed) Speed(mean int, at time.Time) int {
+	// the idle to burst probability keeps the fraction of intervals in burst equal to duty
+	switchProbability := (1 - b.stay) * b.duty / (1 - b.duty)
+	if b.active {
+		switchProbability = 1 - b.stay
+	}
+	if b.rng.Float64() < switchProbability {
+		b.active = !b.active
+	}
+	if !b.active {
+		return 0
+	}
+	return int(float64(mean) / b.duty)
+}
@@ -0,0 +1,125 @@
+package ratiospoof
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+const (
+	testMeanSpeed = 100 * 1024
+	testSamples   = 20000
+)
+
+func sampleSpeeds(t *testing.T, name string) []float64 {
+	t.Helper()
+	model, err := NewSpeedModel(name, rand.New(rand.NewSource(1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := time.Date(2021, 5, 10, 0, 0, 0, 0, time.UTC)
+	samples := make([]float64, testSamples)
+	for i := range samples {
+		samples[i] = float64(model.Speed(testMeanSpeed, at))
+		at = at.Add(30 * time.Minute)
+	}
+	return samples
+}
+
+func meanAndDeviation(samples []float64) (mean, deviation float64) {
+	for _, s := range samples {
+		mean += s
+	}
+	mean /= float64(len(samples))
+	for _, s := range samples {
+		deviation += (s - mean) * (s - mean)
+	}
+	return mean, math.Sqrt(deviation / float64(len(samples)))
+}
+
+func checkClose(t *testing.T, what string, got, want, tolerance float64) {
+	t.Helper()
+	if math.Abs(got-want) > tolerance*want {
+		t.Errorf("%v got: %.0f want %.0f (±%v%%)", what, got, want, tolerance*100)
+	}
+}
+
+func TestConstantSpeed(t *testing.T) {
+	for _, s := range sampleSpeeds(t, "constant") {
+		if s != testMeanSpeed {
+			t.Fatalf("got: %v want %v", s, testMeanSpeed)
+		}
+	}
+}
+
+func TestUniformSpeed(t *testing.T) {
+	samples := sampleSpeeds(t, "uniform")
+	low, high := testMeanSpeed*(1-uniformSpread), testMeanSpeed*(1+uniformSpread)
+	for _, s := range samples {
+		if s < low || s > high {
+			t.Fatalf("got: %v want between %v and %v", s, low, high)
+		}
+	}
+	mean, deviation := meanAndDeviation(samples)
+	checkClose(t, "mean", mean, testMeanSpeed, 0.02)
+	// the standard deviation of a uniform distribution is its width/sqrt(12)
+	checkClose(t, "deviation", deviation, (high-low)/math.Sqrt(12), 0.05)
+}
+
+func TestGaussianSpeed(t *testing.T) {
+	samples := sampleSpeeds(t, "gaussian")
+	mean, deviation := meanAndDeviation(samples)
+	checkClose(t, "mean", mean, testMeanSpeed, 0.02)
+	checkClose(t, "deviation", deviation, testMeanSpeed*gaussianDeviation, 0.05)
+
+	var withinOneDeviation int
+	for _, s := range samples {
+		if math.Abs(s-testMeanSpeed) <= testMeanSpeed*gaussianDeviation {
+			withinOneDeviation++
+		}
+	}
+	// about 68% of a normal distribution is within one standard deviation
+	checkClose(t, "within one deviation", float64(withinOneDeviation)/testSamples, 0.6827, 0.03)
+}
+
+func TestDiurnalSpeed(t *testing.T) {
+	samples := sampleSpeeds(t, "diurnal")
+	mean, _ := meanAndDeviation(samples)
+	checkClose(t, "daily mean", mean, testMeanSpeed, 0.01)
+
+	model := diurnalSpeed{amplitude: diurnalAmplitude, peakHour: diurnalPeakHour}
+	peak := model.Speed(testMeanSpeed, time.Date(2021, 5, 10, diurnalPeakHour, 0, 0, 0, time.UTC))
+	trough := model.Speed(testMeanSpeed, time.Date(2021, 5, 10, diurnalPeakHour-12, 0, 0, 0, time.UTC))
+	checkClose(t, "peak", float64(peak), testMeanSpeed*(1+diurnalAmplitude), 0.001)
+	checkClose(t, "trough", float64(trough), testMeanSpeed*(1-diurnalAmplitude), 0.001)
+}
+
+func TestBurstySpeed(t *testing.T) {
+	samples := sampleSpeeds(t, "bursty")
+	var bursts, switches int
+	for i, s := range samples {
+		if s != 0 && s != testMeanSpeed/burstyDuty {
+			t.Fatalf("got: %v want 0 or %v", s, testMeanSpeed/burstyDuty)
+		}
+		if s != 0 {
+			bursts++
+		}
+		if i > 0 && s != samples[i-1] {
+			switches++
+		}
+	}
+	mean, _ := meanAndDeviation(samples)
+	checkClose(t, "mean", mean, testMeanSpeed, 0.05)
+	checkClose(t, "duty", float64(bursts)/testSamples, burstyDuty, 0.05)
+	// bursts and idle periods last 1/(1-stay) intervals on average
+	checkClose(t, "switches", float64(switches)/testSamples, 2*(1-burstyStay)*burstyDuty, 0.1)
+}
+
+func TestNewSpeedModelUnknown(t *testing.T) {
+	_, err := NewSpeedModel("turbo", rand.New(rand.NewSource(1)))
+	if !errors.Is(err, ErrUnknownSpeedModel) {
+		t.Errorf("got: %v want %v", err, ErrUnknownSpeedModel)
+	}
+}
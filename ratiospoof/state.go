@@ -0,0 +1,120 @@
+package ratiospoof
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/ap-pauloafonso/ratio-spoof/emulation"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+)
+
+// State is what a run leaves behind for the next one, one file per info hash
+type State struct {
+	InfoHash  string          `json:"infoHash"`
+	Client    string          `json:"client"`
+	PeerId    string          `json:"peerId,omitempty"`
+	Key       string          `json:"key,omitempty"`
+	TrackerId string          `json:"trackerId,omitempty"`
+	History   []AnnounceEntry `json:"history"`
+}
+
+func infoHashHex(infoHashURLEncoded string) (string, error) {
+	raw, err := url.QueryUnescape(infoHashURLEncoded)
+	if err != nil {
+		return "", fmt.Errorf("invalid info hash: %w", err)
+	}
+	return hex.EncodeToString([]byte(raw)), nil
+}
+
+// loadState reads the state saved for the info hash, it returns nil when there is none
+func loadState(dir, infoHashURLEncoded string) (*State, error) {
+	infoHash, err := infoHashHex(infoHashURLEncoded)
+	if err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dir, infoHash+".json")
+	dat, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var state State
+	if err := json.Unmarshal(dat, &state); err != nil {
+		return nil, fmt.Errorf("invalid state file %v: %w", path, err)
+	}
+	return &state, nil
+}
+
+// saveState writes the state of the torrent, the file is replaced atomically so a crash never leaves half of it
+func (r *RatioSpoof) saveState() error {
+	if r.Input.StateDir == "" {
+		return nil
+	}
+	infoHash, err := infoHashHex(r.TorrentInfo.InfoHashURLEncoded)
+	if err != nil {
+		return err
+	}
+	state := State{
+		InfoHash:  infoHash,
+		Client:    r.BitTorrentClient.Name,
+		TrackerId: r.TrackerId,
+	}
+	// the peer id and key are only read back when the client profile persists them
+	if r.BitTorrentClient.PeerIdRefresh == emulation.RefreshPersisted {
+		state.PeerId = r.BitTorrentClient.PeerId()
+	}
+	if r.BitTorrentClient.KeyRefresh == emulation.RefreshPersisted {
+		state.Key = r.BitTorrentClient.Key()
+	}
+	for i := 0; i < r.AnnounceHistory.Len(); i++ {
+		state.History = append(state.History, r.AnnounceHistory.At(i).(AnnounceEntry))
+	}
+	dat, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(r.Input.StateDir, 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(r.Input.StateDir, ".state-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(dat); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(r.Input.StateDir, infoHash+".json"))
+}
+
+// restoreState continues from the counters of a previous run, the peer id and key are only reused
+// when the previous run emulated the same client
+func (r *RatioSpoof) restoreState(state *State) {
+	if len(state.History) == 0 {
+		return
+	}
+	last := state.History[len(state.History)-1]
+	r.Input.InitialDownloaded = last.Downloaded
+	r.Input.InitialUploaded = last.Uploaded
+	// the first announce of this run is pushed on top of the restored history
+	for _, entry := range state.History[:len(state.History)-1] {
+		r.AnnounceHistory.pushValueHistory(entry)
+	}
+	r.AnnounceCount = last.Count - 1
+	r.TrackerId = state.TrackerId
+
+	if state.Client == r.BitTorrentClient.Name {
+		r.BitTorrentClient.Restore(state.PeerId, state.Key)
+	}
+}
@@ -0,0 +1,122 @@
+package ratiospoof
+
+import (
+	"context"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ap-pauloafonso/ratio-spoof/emulation"
+	"github.com/ap-pauloafonso/ratio-spoof/tracker"
+)
+
+func TestStateSaveAndRestore(t *testing.T) {
+	dir := t.TempDir()
+	fake := &fakeTracker{response: tracker.TrackerResponse{Interval: 1800, TrackerId: "abc"}}
+	r := newTestRatioSpoof(t, fake)
+	client, err := emulation.NewEmulation("qbit-4.0.3", rand.New(rand.NewSource(1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.BitTorrentClient = client
+	r.Input.StateDir = dir
+	r.Input.InitialUploaded = 1000
+	if err := r.firstAnnounce(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	r.generateNextAnnounce()
+	if err := r.fireAnnounce(context.Background(), false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "0102.json")); err != nil {
+		t.Fatalf("state file should exist: %v", err)
+	}
+
+	state, err := loadState(dir, r.TorrentInfo.InfoHashURLEncoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := newTestRatioSpoof(t, &fakeTracker{})
+	// a new process generates another identity, the persisted one replaces it
+	restored.BitTorrentClient, err = emulation.NewEmulation("qbit-4.0.3", rand.New(rand.NewSource(2)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored.Input.StateDir = dir
+	restored.restoreState(state)
+	restored.firstAnnounce(context.Background())
+
+	last := r.AnnounceHistory.Back().(AnnounceEntry)
+	got := restored.AnnounceHistory.Back().(AnnounceEntry)
+	if got != last {
+		t.Errorf("got: %v want %v", got, last)
+	}
+	if restored.AnnounceHistory.Len() != r.AnnounceHistory.Len() {
+		t.Errorf("got: %v want %v", restored.AnnounceHistory.Len(), r.AnnounceHistory.Len())
+	}
+	if restored.BitTorrentClient.PeerId() != r.BitTorrentClient.PeerId() {
+		t.Errorf("got: %v want %v", restored.BitTorrentClient.PeerId(), r.BitTorrentClient.PeerId())
+	}
+	if restored.BitTorrentClient.Key() != r.BitTorrentClient.Key() {
+		t.Errorf("got: %v want %v", restored.BitTorrentClient.Key(), r.BitTorrentClient.Key())
+	}
+	if restored.TrackerId != "abc" {
+		t.Errorf("got: %v want %v", restored.TrackerId, "abc")
+	}
+}
+
+func TestSaveStateIdentityNotPersisted(t *testing.T) {
+	dir := t.TempDir()
+	// qBittorrent 4.3.3 generates its peer id and key on every start, they are not saved
+	r := newTestRatioSpoof(t, &fakeTracker{response: tracker.TrackerResponse{Interval: 1800}})
+	r.Input.StateDir = dir
+	if err := r.firstAnnounce(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	state, err := loadState(dir, r.TorrentInfo.InfoHashURLEncoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.PeerId != "" || state.Key != "" {
+		t.Errorf("got: %v, %v want no peer id nor key", state.PeerId, state.Key)
+	}
+}
+
+func TestLoadStateMissing(t *testing.T) {
+	state, err := loadState(t.TempDir(), "%01%02")
+	if state != nil || err != nil {
+		t.Errorf("got: %v, %v want no state", state, err)
+	}
+}
+
+func TestRestoreStateOtherClient(t *testing.T) {
+	r := newTestRatioSpoof(t, &fakeTracker{})
+	peerId := r.BitTorrentClient.PeerId()
+	r.restoreState(&State{Client: "other", PeerId: "-XX0000-000000000000", Key: "ABCDEF01", History: []AnnounceEntry{{Count: 3, Uploaded: 10}}})
+	if r.BitTorrentClient.PeerId() != peerId {
+		t.Errorf("got: %v want %v", r.BitTorrentClient.PeerId(), peerId)
+	}
+	if r.Input.InitialUploaded != 10 {
+		t.Errorf("got: %v want %v", r.Input.InitialUploaded, 10)
+	}
+}
+
+func TestRestoreStateRefreshPolicies(t *testing.T) {
+	r := newTestRatioSpoof(t, &fakeTracker{})
+	client, err := emulation.NewEmulation("utorrent-3.5.5", rand.New(rand.NewSource(1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.BitTorrentClient = client
+	peerId := r.BitTorrentClient.PeerId()
+	r.restoreState(&State{Client: client.Name, PeerId: "-UT355W-000000000000", Key: "ABCDEF01", History: []AnnounceEntry{{Count: 3, Uploaded: 10}}})
+
+	// µTorrent generates its peer id once per process and persists the key of every torrent
+	if r.BitTorrentClient.PeerId() != peerId {
+		t.Errorf("got: %v want %v", r.BitTorrentClient.PeerId(), peerId)
+	}
+	if r.BitTorrentClient.Key() != "ABCDEF01" {
+		t.Errorf("got: %v want %v", r.BitTorrentClient.Key(), "ABCDEF01")
+	}
+}
@@ -0,0 +1,42 @@
+package ratiospoof
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrStopConditionReached is returned by Run when a stop condition ended the run
+var ErrStopConditionReached = errors.New("stop condition reached")
+
+// stopReason returns why the run must stop after the last announce, empty while no byte stop condition is reached
+func (r *RatioSpoof) stopReason() string {
+	lastAnnounce := r.AnnounceHistory.Back().(AnnounceEntry)
+	if r.Input.StopRatio > 0 && float64(lastAnnounce.Uploaded) >= r.Input.StopRatio*float64(r.TorrentInfo.TotalSize) {
+		return fmt.Sprintf("ratio %v", r.Input.StopRatio)
+	}
+	if r.Input.StopUploaded > 0 && lastAnnounce.Uploaded >= r.Input.StopUploaded {
+		return fmt.Sprintf("%v bytes uploaded", r.Input.StopUploaded)
+	}
+	return ""
+}
+
+// stopDeadline returns the earliest time stop condition of a run started at started, zero when there is none
+func (r *RatioSpoof) stopDeadline(started time.Time) time.Time {
+	var deadline time.Time
+	if r.Input.StopAfter > 0 {
+		deadline = started.Add(r.Input.StopAfter)
+	}
+	if !r.Input.StopAt.IsZero() && (deadline.IsZero() || r.Input.StopAt.Before(deadline)) {
+		deadline = r.Input.StopAt
+	}
+	return deadline
+}
+
+// stop sends the stopped announce because of a stop condition
+func (r *RatioSpoof) stop(reason string) error {
+	if err := r.gracefullyExit(); err != nil {
+		return err
+	}
+	return fmt.Errorf("%w: %v", ErrStopConditionReached, reason)
+}
@@ -0,0 +1,30 @@
+package ratiospoof
+
+// swarmUploadSpeed scales the upload speed to the swarm of the last announce: nothing is uploaded without leechers,
+// and when they are fewer than the seeders we compete with, only our share of them is served
+func (r *RatioSpoof) swarmUploadSpeed(speed int) int {
+	if r.Leechers <= 0 {
+		return 0
+	}
+	uploaders := r.Seeders + 1
+	if r.Leechers < uploaders {
+		return speed * r.Leechers / uploaders
+	}
+	return speed
+}
+
+// swarmUploadDemand is the most we can plausibly upload during the run: our share of a whole copy for every leecher
+func (r *RatioSpoof) swarmUploadDemand() int {
+	return r.Leechers * r.TorrentInfo.TotalSize / (r.Seeders + 1)
+}
+
+// swarmCappedUpload caps the uploaded total of the run to the demand of the last swarm, a reported uploaded never goes back
+func (r *RatioSpoof) swarmCappedUpload(currentUploaded, uploadCandidate int) int {
+	if limit := r.swarmUploadStart + r.swarmUploadDemand(); uploadCandidate > limit {
+		uploadCandidate = limit
+	}
+	if uploadCandidate < currentUploaded {
+		return currentUploaded
+	}
+	return uploadCandidate
+}
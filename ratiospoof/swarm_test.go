@@ -0,0 +1,76 @@
+package ratiospoof
+
+import (
+	"testing"
+)
+
+func TestSwarmUploadSpeed(t *testing.T) {
+	data := []struct {
+		name     string
+		seeders  int
+		leechers int
+		speed    int
+		expected int
+	}{
+		{name: "no leechers", seeders: 10, leechers: 0, speed: 1000, expected: 0},
+		{name: "swarm not informed", seeders: 0, leechers: 0, speed: 1000, expected: 0},
+		{name: "more leechers than uploaders", seeders: 3, leechers: 20, speed: 1000, expected: 1000},
+		{name: "leechers shared with seeders", seeders: 9, leechers: 2, speed: 1000, expected: 200},
+		{name: "only leechers", seeders: 0, leechers: 1, speed: 1000, expected: 1000},
+	}
+	for _, td := range data {
+		t.Run(td.name, func(t *testing.T) {
+			r := newTestRatioSpoof(t, &fakeTracker{})
+			r.Seeders, r.Leechers = td.seeders, td.leechers
+			if got := r.swarmUploadSpeed(td.speed); got != td.expected {
+				t.Errorf("got: %v want %v", got, td.expected)
+			}
+		})
+	}
+}
+
+func TestSwarmAwareGenerateNextAnnounce(t *testing.T) {
+	r := newTestRatioSpoof(t, &fakeTracker{})
+	r.Input.SwarmAware = true
+	r.Input.UploadSpeed = 100 * r.TorrentInfo.TotalSize
+	r.AnnounceInterval = 1800
+	r.addAnnounce(r.TorrentInfo.TotalSize, 0, 0, 100)
+
+	r.generateNextAnnounce()
+	if got := r.AnnounceHistory.Back().(AnnounceEntry).Uploaded; got != 0 {
+		t.Errorf("without leechers got: %v want 0", got)
+	}
+
+	r.Seeders, r.Leechers = 1, 4
+	r.generateNextAnnounce()
+	want := 2 * r.TorrentInfo.TotalSize
+	if got := r.AnnounceHistory.Back().(AnnounceEntry).Uploaded; got != want {
+		t.Errorf("capped to the demand got: %v want %v", got, want)
+	}
+}
+
+func TestSwarmAwareCapTotal(t *testing.T) {
+	r := newTestRatioSpoof(t, &fakeTracker{})
+	r.Input.SwarmAware = true
+	r.Input.UploadSpeed = 100 * r.TorrentInfo.TotalSize
+	r.AnnounceInterval = 1800
+	r.Seeders, r.Leechers = 1, 4
+	r.swarmUploadStart = r.TorrentInfo.TotalSize
+	r.addAnnounce(r.TorrentInfo.TotalSize, r.TorrentInfo.TotalSize, 0, 100)
+
+	// the run started with one copy uploaded, it adds the demand of the swarm once and then stops growing
+	want := 3 * r.TorrentInfo.TotalSize
+	for interval := 1; interval <= 3; interval++ {
+		r.generateNextAnnounce()
+		if got := r.AnnounceHistory.Back().(AnnounceEntry).Uploaded; got != want {
+			t.Errorf("interval %v got: %v want %v", interval, got, want)
+		}
+	}
+
+	// fewer leechers never take back what was already reported
+	r.Leechers = 1
+	r.generateNextAnnounce()
+	if got := r.AnnounceHistory.Back().(AnnounceEntry).Uploaded; got != want {
+		t.Errorf("smaller swarm got: %v want %v", got, want)
+	}
+}
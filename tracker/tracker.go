@@ -3,72 +3,349 @@ package tracker
 import (
 	"bytes"
 	"compress/gzip"
+	"context"
+	"encoding/binary"
 	"errors"
+	"fmt"
 	"github.com/ap-pauloafonso/ratio-spoof/bencode"
+	"github.com/ap-pauloafonso/ratio-spoof/clock"
 	"io"
+	"math/rand"
+	"net"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
-type HttpTracker struct {
-	Urls                    []string
+// Tracker is implemented by every transport the engine can announce through
+type Tracker interface {
+	Announce(ctx context.Context, query string, headers map[string]string, retry bool) (*TrackerResponse, error)
+	Scrape(ctx context.Context, infoHash string, headers map[string]string) (*ScrapeResponse, error)
+	Status() Status
+}
+
+// Status is a snapshot of the tracker state, used by the printer
+type Status struct {
+	Url                     string
 	RetryAttempt            int
 	LastAnounceRequest      string
 	LastTackerResponse      string
+	LastWarning             string
+	LastError               string
 	EstimatedTimeToAnnounce time.Time
 }
 
+var (
+	// ErrNoTrackerUrl is returned when the torrent has no url the transport can announce to
+	ErrNoTrackerUrl = errors.New("No tracker url announce found")
+	// ErrTrackerUnreachable is returned when every tracker url failed without a tracker failure reason
+	ErrTrackerUnreachable = errors.New("Connection error with the tracker")
+)
+
+// TrackerError is returned when the tracker answers with a "failure reason", it is permanent
+// unless the tracker asks to be retried later through the BEP 31 "retry in" key or the error is transient
+type TrackerError struct {
+	Reason string
+	// RetryIn is the amount of minutes the tracker asked to wait before retrying, 0 means never
+	RetryIn int
+	// Transient errors carry no retry delay of their own, they are retried with the normal backoff
+	Transient bool
+}
+
+func (e *TrackerError) Error() string {
+	return e.Reason
+}
+
+// Permanent reports whether announcing again can not succeed without user intervention
+func (e *TrackerError) Permanent() bool {
+	return !e.Transient && e.RetryIn <= 0
+}
+
+// IsPermanent reports whether err carries a tracker failure that should not be retried
+func IsPermanent(err error) bool {
+	var trackerErr *TrackerError
+	return errors.As(err, &trackerErr) && trackerErr.Permanent()
+}
+
+type HttpTracker struct {
+	baseTracker
+}
+
 type TrackerResponse struct {
 	MinInterval int
 	Interval    int
 	Seeders     int
 	Leechers    int
+	Peers       []Peer
+	Warning     string
+	TrackerId   string
+}
+
+// Peer is a swarm endpoint returned by the tracker, Id is only filled by the dictionary peer format
+type Peer struct {
+	Id   string
+	IP   net.IP
+	Port int
+}
+
+func (p Peer) String() string {
+	return net.JoinHostPort(p.IP.String(), strconv.Itoa(p.Port))
+}
+
+// ScrapeResponse holds the swarm statistics of a single torrent
+type ScrapeResponse struct {
+	Seeders   int
+	Completed int
+	Leechers  int
+}
+
+// baseTracker holds the url tiers, retry and announce estimation state shared by every tracker transport
+type baseTracker struct {
+	Tiers                   [][]string
+	RetryAttempt            int
+	LastAnounceRequest      string
+	LastTackerResponse      string
+	LastWarning             string
+	LastError               string
+	EstimatedTimeToAnnounce time.Time
+	// answeredUrl is the url that answered the last announce
+	answeredUrl string
+	// minInterval is the min interval of the last successful response, no announce is sent sooner
+	minInterval int
+	clock       clock.Clock
+	rng         *rand.Rand
+}
+
+// MultiTracker announces to the http and udp urls of the torrent following the same BEP 12 tiers,
+// every url goes through the transport of its scheme
+type MultiTracker struct {
+	baseTracker
+	http *HttpTracker
+	udp  *UdpTracker
+}
+
+// NewTracker builds the tracker for every http and udp url of the torrent.
+// The clock drives the retry backoff and the random source shuffles the tiers
+func NewTracker(torrentInfo *bencode.TorrentInfo, clk clock.Clock, rng *rand.Rand) (Tracker, error) {
+	result := filterTiers(torrentInfo.TrackerInfo.Tiers, "http", "udp")
+	if len(result) == 0 {
+		return nil, ErrNoTrackerUrl
+	}
+	return newMultiTracker(shuffleTiers(result, rng), clk, rng), nil
+}
+
+func newMultiTracker(tiers [][]string, clk clock.Clock, rng *rand.Rand) *MultiTracker {
+	return &MultiTracker{
+		baseTracker: baseTracker{Tiers: tiers, clock: clk, rng: rng},
+		http:        &HttpTracker{baseTracker{clock: clk, rng: rng}},
+		udp:         newUdpTracker(nil, clk, rng),
+	}
+}
+
+// Announce sends the announce to the first url that answers, whatever its transport
+func (t *MultiTracker) Announce(ctx context.Context, query string, headers map[string]string, retry bool) (*TrackerResponse, error) {
+	return t.announce(ctx, retry, func(ctx context.Context, trackerUrl string) (*TrackerResponse, error) {
+		var resp *TrackerResponse
+		var err error
+		if strings.HasPrefix(trackerUrl, "udp") {
+			resp, err = t.udp.announceUrl(ctx, trackerUrl, query)
+			t.LastAnounceRequest, t.LastTackerResponse = t.udp.LastAnounceRequest, t.udp.LastTackerResponse
+		} else {
+			resp, err = t.http.tryMakeRequest(ctx, trackerUrl, query, headers)
+			t.LastAnounceRequest, t.LastTackerResponse = t.http.LastAnounceRequest, t.http.LastTackerResponse
+		}
+		return resp, err
+	})
+}
+
+// Scrape asks the first url that answers for the swarm statistics of the url encoded info hash
+func (t *MultiTracker) Scrape(ctx context.Context, infoHash string, headers map[string]string) (*ScrapeResponse, error) {
+	rawHash, err := rawInfoHash(infoHash)
+	if err != nil {
+		return nil, err
+	}
+	for _, tier := range t.Tiers {
+		for _, trackerUrl := range tier {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			var resp *ScrapeResponse
+			if strings.HasPrefix(trackerUrl, "udp") {
+				resp, err = t.udp.tryScrape(ctx, trackerUrl, []byte(rawHash))
+			} else {
+				resp, err = t.http.tryScrape(ctx, trackerUrl, infoHash, headers)
+			}
+			if err != nil {
+				continue
+			}
+			return resp, nil
+		}
+	}
+	return nil, ErrTrackerUnreachable
 }
 
-func NewHttpTracker(torrentInfo *bencode.TorrentInfo) (*HttpTracker, error) {
+// ScrapeResult is the outcome of scraping a single tracker url
+type ScrapeResult struct {
+	Url      string
+	Response *ScrapeResponse
+	Err      error
+}
+
+// scrapeTimeout bounds the scrape of each tracker url, an unresponsive udp tracker would otherwise retransmit for minutes
+const scrapeTimeout = 30 * time.Second
+
+// ScrapeAll scrapes every tracker url of the torrent concurrently without announcing, the results are in tier order
+func ScrapeAll(ctx context.Context, torrentInfo *bencode.TorrentInfo, headers map[string]string) []ScrapeResult {
+	return scrapeAll(ctx, torrentInfo, headers, scrapeTimeout)
+}
 
-	var result []string
-	for _, url := range torrentInfo.TrackerInfo.Urls {
-		if strings.HasPrefix(url, "http") {
-			result = append(result, url)
+func scrapeAll(ctx context.Context, torrentInfo *bencode.TorrentInfo, headers map[string]string, timeout time.Duration) []ScrapeResult {
+	var results []ScrapeResult
+	for _, tier := range torrentInfo.TrackerInfo.Tiers {
+		for _, trackerUrl := range tier {
+			results = append(results, ScrapeResult{Url: trackerUrl})
 		}
 	}
+	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+	var wg sync.WaitGroup
+	for i := range results {
+		// the udp tracker caches connection ids and draws transaction ids, every goroutine gets its own
+		udpTracker := newUdpTracker(nil, clock.Real, rand.New(rand.NewSource(rng.Int63())))
+		wg.Add(1)
+		go func(result *ScrapeResult) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			switch {
+			case strings.HasPrefix(result.Url, "http"):
+				result.Response, result.Err = (&HttpTracker{}).tryScrape(ctx, result.Url, torrentInfo.InfoHashURLEncoded, headers)
+			case strings.HasPrefix(result.Url, "udp"):
+				var rawHash string
+				if rawHash, result.Err = rawInfoHash(torrentInfo.InfoHashURLEncoded); result.Err == nil {
+					result.Response, result.Err = udpTracker.tryScrape(ctx, result.Url, []byte(rawHash))
+				}
+			default:
+				result.Err = fmt.Errorf("tracker %v protocol is not supported", result.Url)
+			}
+		}(&results[i])
+	}
+	wg.Wait()
+	return results
+}
+
+func NewHttpTracker(torrentInfo *bencode.TorrentInfo, clk clock.Clock, rng *rand.Rand) (*HttpTracker, error) {
+	result := filterTiers(torrentInfo.TrackerInfo.Tiers, "http")
 	if len(result) == 0 {
-		return nil, errors.New("No tcp/http tracker url announce found")
+		return nil, fmt.Errorf("%w (tcp/http)", ErrNoTrackerUrl)
+	}
+	return &HttpTracker{baseTracker{Tiers: shuffleTiers(result, rng), clock: clk, rng: rng}}, nil
+}
+
+// filterTiers keeps only the urls with one of the given schemes, dropping the tiers left empty
+func filterTiers(tiers [][]string, schemes ...string) [][]string {
+	var result [][]string
+	for _, tier := range tiers {
+		var filtered []string
+		for _, url := range tier {
+			for _, scheme := range schemes {
+				if strings.HasPrefix(url, scheme) {
+					filtered = append(filtered, url)
+					break
+				}
+			}
+		}
+		if len(filtered) > 0 {
+			result = append(result, filtered)
+		}
 	}
-	return &HttpTracker{Urls: torrentInfo.TrackerInfo.Urls}, nil
+	return result
 }
 
-func (t *HttpTracker) swapFirst(currentIdx int) {
-	aux := t.Urls[0]
-	t.Urls[0] = t.Urls[currentIdx]
-	t.Urls[currentIdx] = aux
+// shuffleTiers randomizes the order inside each tier, like clients do when the torrent is loaded (BEP 12)
+func shuffleTiers(tiers [][]string, rng *rand.Rand) [][]string {
+	for _, tier := range tiers {
+		rng.Shuffle(len(tier), func(i, j int) {
+			tier[i], tier[j] = tier[j], tier[i]
+		})
+	}
+	return tiers
 }
 
-func (t *HttpTracker) updateEstimatedTimeToAnnounce(interval int) {
-	t.EstimatedTimeToAnnounce = time.Now().Add(time.Duration(interval) * time.Second)
+func (t *baseTracker) Status() Status {
+	// before any answer the url shown is the first one tried
+	url := t.answeredUrl
+	if url == "" && len(t.Tiers) > 0 {
+		url = t.Tiers[0][0]
+	}
+	return Status{
+		Url:                     url,
+		RetryAttempt:            t.RetryAttempt,
+		LastAnounceRequest:      t.LastAnounceRequest,
+		LastTackerResponse:      t.LastTackerResponse,
+		LastWarning:             t.LastWarning,
+		LastError:               t.LastError,
+		EstimatedTimeToAnnounce: t.EstimatedTimeToAnnounce,
+	}
+}
+
+// promote moves the url to the front of its own tier, the other tiers are left untouched
+func (t *baseTracker) promote(tierIdx, urlIdx int) {
+	tier := t.Tiers[tierIdx]
+	url := tier[urlIdx]
+	copy(tier[1:urlIdx+1], tier[:urlIdx])
+	tier[0] = url
 }
-func (t *HttpTracker) handleSuccessfulResponse(resp *TrackerResponse) {
+
+func (t *baseTracker) updateEstimatedTimeToAnnounce(interval int) {
+	t.EstimatedTimeToAnnounce = t.clock.Now().Add(time.Duration(interval) * time.Second)
+}
+func (t *baseTracker) handleSuccessfulResponse(resp *TrackerResponse) {
 	if resp.Interval <= 0 {
 		resp.Interval = 1800
 	}
+	// the next announce can never happen before the min interval
+	if resp.MinInterval > resp.Interval {
+		resp.Interval = resp.MinInterval
+	}
+	t.minInterval = resp.MinInterval
 
+	t.LastWarning = resp.Warning
+	t.LastError = ""
 	t.updateEstimatedTimeToAnnounce(resp.Interval)
 }
 
-func (t *HttpTracker) Announce(query string, headers map[string]string, retry bool) (*TrackerResponse, error) {
+func (t *baseTracker) announce(ctx context.Context, retry bool, request func(ctx context.Context, url string) (*TrackerResponse, error)) (*TrackerResponse, error) {
 	defer func() {
 		t.RetryAttempt = 0
 	}()
 	if retry {
 		retryDelay := 30
 		for {
-			trackerResp, err := t.tryMakeRequest(query, headers)
+			trackerResp, err := t.tryUrls(ctx, request)
 			if err != nil {
-				t.updateEstimatedTimeToAnnounce(retryDelay)
+				t.LastError = err.Error()
+				if IsPermanent(err) || ctx.Err() != nil {
+					return nil, err
+				}
+				delay := retryDelay
+				var trackerErr *TrackerError
+				if errors.As(err, &trackerErr) && trackerErr.RetryIn > 0 {
+					delay = trackerErr.RetryIn * 60
+				}
+				// a retry is still an announce, it waits for the min interval of the last response too
+				if delay < t.minInterval {
+					delay = t.minInterval
+				}
+				t.updateEstimatedTimeToAnnounce(delay)
 				t.RetryAttempt++
-				time.Sleep(time.Duration(retryDelay) * time.Second)
+				select {
+				case <-ctx.Done():
+					return nil, ctx.Err()
+				case <-t.clock.After(time.Duration(delay) * time.Second):
+				}
 				retryDelay *= 2
 				if retryDelay > 900 {
 					retryDelay = 900
@@ -80,8 +357,9 @@ func (t *HttpTracker) Announce(query string, headers map[string]string, retry bo
 		}
 
 	} else {
-		resp, err := t.tryMakeRequest(query, headers)
+		resp, err := t.tryUrls(ctx, request)
 		if err != nil {
+			t.LastError = err.Error()
 			return nil, err
 		}
 		t.handleSuccessfulResponse(resp)
@@ -89,65 +367,244 @@ func (t *HttpTracker) Announce(query string, headers map[string]string, retry bo
 	}
 }
 
-func (t *HttpTracker) tryMakeRequest(query string, headers map[string]string) (*TrackerResponse, error) {
-	for idx, baseUrl := range t.Urls {
-		completeURL := buildFullUrl(baseUrl, query)
-		t.LastAnounceRequest = completeURL
-		req, _ := http.NewRequest("GET", completeURL, nil)
-		for header, value := range headers {
-			req.Header.Add(header, value)
-		}
-		resp, err := http.DefaultClient.Do(req)
-		if err == nil {
-			if resp.StatusCode == http.StatusOK {
-				bytesR, _ := io.ReadAll(resp.Body)
-				if len(bytesR) == 0 {
-					continue
-				}
-				mimeType := http.DetectContentType(bytesR)
-				if mimeType == "application/x-gzip" {
-					gzipReader, _ := gzip.NewReader(bytes.NewReader(bytesR))
-					bytesR, _ = io.ReadAll(gzipReader)
-					gzipReader.Close()
-				}
-				t.LastTackerResponse = string(bytesR)
-				decodedResp, err := bencode.Decode(bytesR)
-				if err != nil {
-					continue
-				}
-				ret, err := extractTrackerResponse(decodedResp)
-				if err != nil {
-					continue
-				}
-				if idx != 0 {
-					t.swapFirst(idx)
+// tryUrls walks the tiers in order and the urls of each tier in order until one of them answers,
+// that url is promoted to the front of its tier (BEP 12). When every url fails the first
+// tracker failure is returned so its reason reaches the user
+func (t *baseTracker) tryUrls(ctx context.Context, request func(ctx context.Context, url string) (*TrackerResponse, error)) (*TrackerResponse, error) {
+	var trackerErr *TrackerError
+	var lastErr error
+	for tierIdx, tier := range t.Tiers {
+		for urlIdx, url := range tier {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			resp, err := request(ctx, url)
+			if err != nil {
+				if trackerErr == nil {
+					errors.As(err, &trackerErr)
 				}
+				lastErr = err
+				continue
+			}
+			if urlIdx != 0 {
+				t.promote(tierIdx, urlIdx)
+			}
+			t.answeredUrl = url
+			return resp, nil
+		}
+	}
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if trackerErr != nil {
+		return nil, trackerErr
+	}
+	return nil, fmt.Errorf("%w: %v", ErrTrackerUnreachable, lastErr)
+}
+
+func (t *HttpTracker) Announce(ctx context.Context, query string, headers map[string]string, retry bool) (*TrackerResponse, error) {
+	return t.announce(ctx, retry, func(ctx context.Context, url string) (*TrackerResponse, error) {
+		return t.tryMakeRequest(ctx, url, query, headers)
+	})
+}
 
-				return &ret, nil
+// Scrape asks the first http url that answers for the swarm statistics of the url encoded info hash
+func (t *HttpTracker) Scrape(ctx context.Context, infoHash string, headers map[string]string) (*ScrapeResponse, error) {
+	for _, tier := range t.Tiers {
+		for _, url := range tier {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
 			}
-			resp.Body.Close()
+			resp, err := t.tryScrape(ctx, url, infoHash, headers)
+			if err != nil {
+				continue
+			}
+			return resp, nil
 		}
 	}
-	return nil, errors.New("Connection error with the tracker")
+	return nil, ErrTrackerUnreachable
+}
+
+func (t *HttpTracker) tryMakeRequest(ctx context.Context, baseUrl, query string, headers map[string]string) (*TrackerResponse, error) {
+	completeURL := BuildFullUrl(baseUrl, query)
+	t.LastAnounceRequest = completeURL
+	bytesR, err := httpGet(ctx, completeURL, headers)
+	if err != nil {
+		return nil, err
+	}
+	t.LastTackerResponse = string(bytesR)
+	decodedResp, err := bencode.Decode(bytesR)
+	if err != nil {
+		return nil, err
+	}
+	ret, err := extractTrackerResponse(decodedResp)
+	if err != nil {
+		return nil, err
+	}
+	return &ret, nil
+}
 
+func (t *HttpTracker) tryScrape(ctx context.Context, announceUrl, infoHash string, headers map[string]string) (*ScrapeResponse, error) {
+	rawHash, err := rawInfoHash(infoHash)
+	if err != nil {
+		return nil, err
+	}
+	scrape, err := scrapeUrl(announceUrl)
+	if err != nil {
+		return nil, err
+	}
+	bytesR, err := httpGet(ctx, BuildFullUrl(scrape, "info_hash="+infoHash), headers)
+	if err != nil {
+		return nil, err
+	}
+	decodedResp, err := bencode.Decode(bytesR)
+	if err != nil {
+		return nil, err
+	}
+	return extractScrapeResponse(decodedResp, rawHash)
 }
 
-func buildFullUrl(baseurl, query string) string {
+func httpGet(ctx context.Context, url string, headers map[string]string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	for header, value := range headers {
+		req.Header.Add(header, value)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New(resp.Status)
+	}
+	bytesR, _ := io.ReadAll(resp.Body)
+	if len(bytesR) == 0 {
+		return nil, errors.New("empty tracker response")
+	}
+	mimeType := http.DetectContentType(bytesR)
+	if mimeType == "application/x-gzip" {
+		gzipReader, _ := gzip.NewReader(bytes.NewReader(bytesR))
+		bytesR, _ = io.ReadAll(gzipReader)
+		gzipReader.Close()
+	}
+	return bytesR, nil
+}
+
+// scrapeUrl derives the scrape url from the announce url by the convention of replacing
+// the "announce" at the start of the last path segment with "scrape" (BEP 48)
+func scrapeUrl(announceUrl string) (string, error) {
+	query := ""
+	if idx := strings.Index(announceUrl, "?"); idx >= 0 {
+		announceUrl, query = announceUrl[:idx], announceUrl[idx:]
+	}
+	lastSlash := strings.LastIndex(announceUrl, "/")
+	if lastSlash < 0 || !strings.HasPrefix(announceUrl[lastSlash+1:], "announce") {
+		return "", fmt.Errorf("tracker %v does not support scrape", announceUrl)
+	}
+	return announceUrl[:lastSlash+1] + "scrape" + announceUrl[lastSlash+1+len("announce"):] + query, nil
+}
+
+// rawInfoHash decodes the url encoded info hash back to its 20 bytes
+func rawInfoHash(infoHash string) (string, error) {
+	raw, err := url.QueryUnescape(infoHash)
+	if err != nil {
+		return "", err
+	}
+	if len(raw) != 20 {
+		return "", errors.New("info hash must have 20 bytes")
+	}
+	return raw, nil
+}
+
+// BuildFullUrl appends the query to the tracker url, keeping the parameters the url already has
+func BuildFullUrl(baseurl, query string) string {
 	if len(strings.Split(baseurl, "?")) > 1 {
 		return baseurl + "&" + strings.TrimLeft(query, "&")
 	}
 	return baseurl + "?" + strings.TrimLeft(query, "?")
 }
 
+func extractScrapeResponse(dataScrapeResponse map[string]interface{}, rawInfoHash string) (*ScrapeResponse, error) {
+	if err := extractTrackerError(dataScrapeResponse); err != nil {
+		return nil, err
+	}
+	files, _ := dataScrapeResponse["files"].(map[string]interface{})
+	file, ok := files[rawInfoHash].(map[string]interface{})
+	if !ok {
+		return nil, errors.New("torrent not found in the scrape response")
+	}
+	var result ScrapeResponse
+	result.Seeders, _ = file["complete"].(int)
+	result.Completed, _ = file["downloaded"].(int)
+	result.Leechers, _ = file["incomplete"].(int)
+	return &result, nil
+}
+
+func extractTrackerError(dataResponse map[string]interface{}) error {
+	v, ok := dataResponse["failure reason"].(string)
+	if !ok || len(v) == 0 {
+		return nil
+	}
+	retryIn, _ := dataResponse["retry in"].(int)
+	return &TrackerError{Reason: v, RetryIn: retryIn}
+}
+
 func extractTrackerResponse(datatrackerResponse map[string]interface{}) (TrackerResponse, error) {
 	var result TrackerResponse
-	if v, ok := datatrackerResponse["failure reason"].(string); ok && len(v) > 0 {
-		return result, errors.New(v)
+	if err := extractTrackerError(datatrackerResponse); err != nil {
+		return result, err
 	}
+	result.Warning, _ = datatrackerResponse["warning message"].(string)
 	result.MinInterval, _ = datatrackerResponse["min interval"].(int)
 	result.Interval, _ = datatrackerResponse["interval"].(int)
 	result.Seeders, _ = datatrackerResponse["complete"].(int)
 	result.Leechers, _ = datatrackerResponse["incomplete"].(int)
+	result.TrackerId, _ = datatrackerResponse["tracker id"].(string)
+	result.Peers = extractPeers(datatrackerResponse)
 	return result, nil
 
 }
+
+// extractPeers reads the "peers" key in the compact (BEP 23) or dictionary format and the compact "peers6" key (BEP 7)
+func extractPeers(datatrackerResponse map[string]interface{}) []Peer {
+	var result []Peer
+	switch peers := datatrackerResponse["peers"].(type) {
+	case string:
+		result = append(result, decodeCompactPeers([]byte(peers), net.IPv4len)...)
+	case []interface{}:
+		for _, item := range peers {
+			dict, ok := item.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			ipStr, _ := dict["ip"].(string)
+			ip := net.ParseIP(ipStr)
+			port, _ := dict["port"].(int)
+			if ip == nil || port <= 0 {
+				continue
+			}
+			id, _ := dict["peer id"].(string)
+			result = append(result, Peer{Id: id, IP: ip, Port: port})
+		}
+	}
+	if peers6, ok := datatrackerResponse["peers6"].(string); ok {
+		result = append(result, decodeCompactPeers([]byte(peers6), net.IPv6len)...)
+	}
+	return result
+}
+
+// decodeCompactPeers decodes the ip followed by the big endian port of each peer, a trailing partial entry is ignored
+func decodeCompactPeers(data []byte, ipLen int) []Peer {
+	entryLen := ipLen + 2
+	var result []Peer
+	for i := 0; i+entryLen <= len(data); i += entryLen {
+		ip := make(net.IP, ipLen)
+		copy(ip, data[i:i+ipLen])
+		port := int(binary.BigEndian.Uint16(data[i+ipLen : i+entryLen]))
+		result = append(result, Peer{IP: ip, Port: port})
+	}
+	return result
+}
@@ -1,37 +1,107 @@
 package tracker
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"github.com/ap-pauloafonso/ratio-spoof/bencode"
+	"github.com/ap-pauloafonso/ratio-spoof/clock"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
 	"reflect"
+	"sort"
 	"testing"
+	"time"
 )
 
 func TestNewHttpTracker(t *testing.T) {
-	_, err := NewHttpTracker(&bencode.TorrentInfo{TrackerInfo: &bencode.TrackerInfo{Urls: []string{"udp://url1", "udp://url2"}}})
+	_, err := NewHttpTracker(&bencode.TorrentInfo{TrackerInfo: &bencode.TrackerInfo{Tiers: [][]string{{"udp://url1", "udp://url2"}}}}, clock.Real, newTestRand())
+	if !errors.Is(err, ErrNoTrackerUrl) {
+		t.Errorf("got: %v want %v", err, ErrNoTrackerUrl)
+	}
 	got := err.Error()
-	want := "No tcp/http tracker url announce found"
+	want := "No tracker url announce found (tcp/http)"
 
 	if got != want {
 		t.Errorf("got: %v want %v", got, want)
 	}
 }
 
-func TestSwapFirst(t *testing.T) {
-	tracker, _ := NewHttpTracker(&bencode.TorrentInfo{TrackerInfo: &bencode.TrackerInfo{Urls: []string{"http://url1", "http://url2", "http://url3", "http://url4"}}})
-	tracker.swapFirst(3)
+func TestPromote(t *testing.T) {
+	tracker := &HttpTracker{baseTracker{Tiers: [][]string{{"http://url1", "http://url2"}, {"http://url3", "http://url4", "http://url5", "http://url6"}}, clock: clock.Real}}
+	tracker.promote(1, 2)
 
-	got := tracker.Urls
-	want := []string{"http://url4", "http://url2", "http://url3", "http://url1"}
+	got := tracker.Tiers
+	want := [][]string{{"http://url1", "http://url2"}, {"http://url5", "http://url3", "http://url4", "http://url6"}}
 
 	if !reflect.DeepEqual(got, want) {
 		t.Errorf("got: %v want %v", got, want)
 	}
 }
 
+func TestTryUrls(t *testing.T) {
+	newTracker := func() *HttpTracker {
+		return &HttpTracker{baseTracker{Tiers: [][]string{{"http://a1", "http://a2"}, {"http://b1", "http://b2"}}, clock: clock.Real}}
+	}
+
+	t.Run("Tiers are tried in order and the working url is promoted inside its tier", func(t *testing.T) {
+		tracker := newTracker()
+		var tried []string
+		_, err := tracker.tryUrls(context.Background(), func(ctx context.Context, url string) (*TrackerResponse, error) {
+			tried = append(tried, url)
+			if url != "http://b2" {
+				return nil, errors.New("down")
+			}
+			return &TrackerResponse{}, nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		wantTried := []string{"http://a1", "http://a2", "http://b1", "http://b2"}
+		if !reflect.DeepEqual(tried, wantTried) {
+			t.Errorf("got: %v want %v", tried, wantTried)
+		}
+		want := [][]string{{"http://a1", "http://a2"}, {"http://b2", "http://b1"}}
+		if !reflect.DeepEqual(tracker.Tiers, want) {
+			t.Errorf("got: %v want %v", tracker.Tiers, want)
+		}
+		if got := tracker.Status().Url; got != "http://b2" {
+			t.Errorf("got: %v want %v", got, "http://b2")
+		}
+	})
+
+	t.Run("Every url down should return error", func(t *testing.T) {
+		tracker := newTracker()
+		_, err := tracker.tryUrls(context.Background(), func(ctx context.Context, url string) (*TrackerResponse, error) {
+			return nil, errors.New("down")
+		})
+		if !errors.Is(err, ErrTrackerUnreachable) {
+			t.Errorf("got: %v want %v", err, ErrTrackerUnreachable)
+		}
+	})
+}
+
+func TestNewHttpTrackerTiers(t *testing.T) {
+	tracker, err := NewHttpTracker(&bencode.TorrentInfo{TrackerInfo: &bencode.TrackerInfo{Tiers: [][]string{{"udp://a1"}, {"udp://b1", "http://b2", "http://b3"}}}}, clock.Real, newTestRand())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tracker.Tiers) != 1 {
+		t.Fatalf("got %v tiers want 1", len(tracker.Tiers))
+	}
+	got := append([]string(nil), tracker.Tiers[0]...)
+	sort.Strings(got)
+	want := []string{"http://b2", "http://b3"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got: %v want %v", got, want)
+	}
+}
+
 func TestHandleSuccessfulResponse(t *testing.T) {
 
 	t.Run("Empty interval should be overided with 1800 ", func(t *testing.T) {
-		tracker, _ := NewHttpTracker(&bencode.TorrentInfo{TrackerInfo: &bencode.TrackerInfo{Urls: []string{"http://url1", "http://url2", "http://url3", "http://url4"}}})
+		tracker, _ := NewHttpTracker(&bencode.TorrentInfo{TrackerInfo: &bencode.TrackerInfo{Tiers: [][]string{{"http://url1", "http://url2", "http://url3", "http://url4"}}}}, clock.Real, newTestRand())
 		r := TrackerResponse{}
 		tracker.handleSuccessfulResponse(&r)
 		got := r.Interval
@@ -43,7 +113,7 @@ func TestHandleSuccessfulResponse(t *testing.T) {
 	})
 
 	t.Run("Valid interval shouldn't be overwritten", func(t *testing.T) {
-		tracker, _ := NewHttpTracker(&bencode.TorrentInfo{TrackerInfo: &bencode.TrackerInfo{Urls: []string{"http://url1", "http://url2", "http://url3", "http://url4"}}})
+		tracker, _ := NewHttpTracker(&bencode.TorrentInfo{TrackerInfo: &bencode.TrackerInfo{Tiers: [][]string{{"http://url1", "http://url2", "http://url3", "http://url4"}}}}, clock.Real, newTestRand())
 		r := TrackerResponse{Interval: 900}
 		tracker.handleSuccessfulResponse(&r)
 		got := r.Interval
@@ -55,3 +125,400 @@ func TestHandleSuccessfulResponse(t *testing.T) {
 	})
 
 }
+
+func TestNewTracker(t *testing.T) {
+	data := []struct {
+		name  string
+		tiers [][]string
+		want  [][]string
+	}{
+		{name: "mixed tiers keep their order", tiers: [][]string{{"http://url1"}, {"udp://url2"}, {"https://url3"}}, want: [][]string{{"http://url1"}, {"udp://url2"}, {"https://url3"}}},
+		{name: "udp only", tiers: [][]string{{"udp://url1"}}, want: [][]string{{"udp://url1"}}},
+		{name: "unsupported urls are dropped", tiers: [][]string{{"wss://url1", "udp://url2"}, {"wss://url3"}}, want: [][]string{{"udp://url2"}}},
+	}
+	for _, td := range data {
+		t.Run(td.name, func(t *testing.T) {
+			got, err := NewTracker(&bencode.TorrentInfo{TrackerInfo: &bencode.TrackerInfo{Tiers: td.tiers}}, clock.Real, newTestRand())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tiers := got.(*MultiTracker).Tiers; !reflect.DeepEqual(tiers, td.want) {
+				t.Errorf("got: %v want %v", tiers, td.want)
+			}
+		})
+	}
+
+	t.Run("No supported url should return error", func(t *testing.T) {
+		_, err := NewTracker(&bencode.TorrentInfo{TrackerInfo: &bencode.TrackerInfo{Tiers: [][]string{{"wss://url1"}}}}, clock.Real, newTestRand())
+		if !errors.Is(err, ErrNoTrackerUrl) {
+			t.Errorf("got: %v want %v", err, ErrNoTrackerUrl)
+		}
+	})
+}
+
+func TestScrapeUrl(t *testing.T) {
+	data := []struct {
+		in  string
+		out string
+		err bool
+	}{
+		{in: "http://example.com/announce", out: "http://example.com/scrape"},
+		{in: "http://example.com/x/announce", out: "http://example.com/x/scrape"},
+		{in: "http://example.com/announce.php", out: "http://example.com/scrape.php"},
+		{in: "http://example.com/passkey/announce?a=b", out: "http://example.com/passkey/scrape?a=b"},
+		{in: "http://example.com/a", err: true},
+		{in: "http://example.com/announce?x=2/4", out: "http://example.com/scrape?x=2/4"},
+	}
+	for _, td := range data {
+		t.Run(td.in, func(t *testing.T) {
+			got, err := scrapeUrl(td.in)
+			if td.err {
+				if err == nil {
+					t.Error("should return error")
+				}
+				return
+			}
+			if got != td.out {
+				t.Errorf("got: %v want %v", got, td.out)
+			}
+		})
+	}
+}
+
+func TestHttpScrape(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/scrape" || r.URL.Query().Get("info_hash") != string(testInfoHash) {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprintf(w, "d5:filesd20:%sd8:completei5e10:downloadedi50e10:incompletei10eeee", testInfoHash)
+	}))
+	defer server.Close()
+	torrentInfo := &bencode.TorrentInfo{
+		InfoHashURLEncoded: testInfoHashEncoded,
+		TrackerInfo:        &bencode.TrackerInfo{Tiers: [][]string{{server.URL + "/announce"}, {"wss://url1"}}},
+	}
+
+	t.Run("Scrape", func(t *testing.T) {
+		tracker, _ := NewHttpTracker(torrentInfo, clock.Real, newTestRand())
+		got, err := tracker.Scrape(context.Background(), testInfoHashEncoded, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := &ScrapeResponse{Seeders: 5, Completed: 50, Leechers: 10}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got: %v want %v", got, want)
+		}
+	})
+
+	t.Run("ScrapeAll reports every url", func(t *testing.T) {
+		results := ScrapeAll(context.Background(), torrentInfo, nil)
+		if len(results) != 2 {
+			t.Fatalf("got %v results want 2", len(results))
+		}
+		if results[0].Err != nil || results[0].Response.Seeders != 5 {
+			t.Errorf("got: %v %v", results[0].Response, results[0].Err)
+		}
+		if results[1].Url != "wss://url1" || results[1].Err == nil {
+			t.Errorf("unsupported url should return error")
+		}
+	})
+
+	t.Run("ScrapeAll bounds every url", func(t *testing.T) {
+		silent := newUdpTestTracker(t, func(packet []byte) []byte { return nil })
+		torrentInfo := &bencode.TorrentInfo{
+			InfoHashURLEncoded: testInfoHashEncoded,
+			TrackerInfo:        &bencode.TrackerInfo{Tiers: [][]string{{silent.url()}, {server.URL + "/announce"}}},
+		}
+		start := time.Now()
+		results := scrapeAll(context.Background(), torrentInfo, nil, 100*time.Millisecond)
+		if time.Since(start) > 5*time.Second {
+			t.Errorf("silent tracker should be given up after the timeout")
+		}
+		if !errors.Is(results[0].Err, context.DeadlineExceeded) {
+			t.Errorf("got: %v want %v", results[0].Err, context.DeadlineExceeded)
+		}
+		if results[1].Err != nil || results[1].Response.Seeders != 5 {
+			t.Errorf("got: %v %v", results[1].Response, results[1].Err)
+		}
+	})
+}
+
+func TestExtractScrapeResponse(t *testing.T) {
+	t.Run("Failure reason", func(t *testing.T) {
+		_, err := extractScrapeResponse(map[string]interface{}{"failure reason": "not allowed"}, string(testInfoHash))
+		if err == nil || err.Error() != "not allowed" {
+			t.Errorf("got: %v want %v", err, "not allowed")
+		}
+	})
+	t.Run("Missing torrent", func(t *testing.T) {
+		_, err := extractScrapeResponse(map[string]interface{}{"files": map[string]interface{}{}}, string(testInfoHash))
+		if err == nil {
+			t.Error("should return error")
+		}
+	})
+}
+
+func TestExtractPeers(t *testing.T) {
+	data := []struct {
+		name string
+		in   map[string]interface{}
+		want []string
+	}{
+		{
+			name: "Compact ipv4",
+			in:   map[string]interface{}{"peers": "\x0a\x00\x00\x01\x1a\xe1\xc0\xa8\x01\x02\x00\x50"},
+			want: []string{"10.0.0.1:6881", "192.168.1.2:80"},
+		},
+		{
+			name: "Compact ipv4 ignores trailing partial entry",
+			in:   map[string]interface{}{"peers": "\x0a\x00\x00\x01\x1a\xe1\xc0\xa8"},
+			want: []string{"10.0.0.1:6881"},
+		},
+		{
+			name: "Compact ipv6",
+			in:   map[string]interface{}{"peers6": "\x20\x01\x0d\xb8\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x01\x1a\xe1"},
+			want: []string{"[2001:db8::1]:6881"},
+		},
+		{
+			name: "Dictionary peers",
+			in: map[string]interface{}{"peers": []interface{}{
+				map[string]interface{}{"peer id": "-qB4330-abcdefghijkl", "ip": "10.0.0.1", "port": 6881},
+				map[string]interface{}{"ip": "2001:db8::2", "port": 51413},
+				map[string]interface{}{"ip": "not an ip", "port": 1},
+			}},
+			want: []string{"10.0.0.1:6881", "[2001:db8::2]:51413"},
+		},
+		{
+			name: "Compact ipv4 and ipv6",
+			in: map[string]interface{}{
+				"peers":  "\x0a\x00\x00\x01\x1a\xe1",
+				"peers6": "\x20\x01\x0d\xb8\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x01\x1a\xe1",
+			},
+			want: []string{"10.0.0.1:6881", "[2001:db8::1]:6881"},
+		},
+	}
+	for _, td := range data {
+		t.Run(td.name, func(t *testing.T) {
+			var got []string
+			for _, peer := range extractPeers(td.in) {
+				got = append(got, peer.String())
+			}
+			if !reflect.DeepEqual(got, td.want) {
+				t.Errorf("got: %v want %v", got, td.want)
+			}
+		})
+	}
+
+	t.Run("Dictionary peer id is kept", func(t *testing.T) {
+		got := extractPeers(map[string]interface{}{"peers": []interface{}{
+			map[string]interface{}{"peer id": "-qB4330-abcdefghijkl", "ip": "10.0.0.1", "port": 6881},
+		}})
+		if got[0].Id != "-qB4330-abcdefghijkl" {
+			t.Errorf("got: %v want %v", got[0].Id, "-qB4330-abcdefghijkl")
+		}
+	})
+}
+
+func TestHttpAnnouncePeers(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "d8:intervali900e5:peers12:\x0a\x00\x00\x01\x1a\xe1\xc0\xa8\x01\x02\x00\x50e")
+	}))
+	defer server.Close()
+	tracker, _ := NewHttpTracker(&bencode.TorrentInfo{TrackerInfo: &bencode.TrackerInfo{Tiers: [][]string{{server.URL + "/announce"}}}}, clock.Real, newTestRand())
+
+	got, err := tracker.Announce(context.Background(), "info_hash=abc", nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Peers) != 2 || got.Peers[1].String() != "192.168.1.2:80" {
+		t.Errorf("got: %v", got.Peers)
+	}
+}
+
+func TestExtractTrackerResponseFailure(t *testing.T) {
+	t.Run("Failure reason is a permanent tracker error", func(t *testing.T) {
+		_, err := extractTrackerResponse(map[string]interface{}{"failure reason": "unregistered torrent"})
+		var trackerErr *TrackerError
+		if !errors.As(err, &trackerErr) || trackerErr.Reason != "unregistered torrent" {
+			t.Fatalf("got: %v want %v", err, "unregistered torrent")
+		}
+		if !IsPermanent(err) {
+			t.Error("should be permanent")
+		}
+	})
+	t.Run("Failure reason with retry in is transient", func(t *testing.T) {
+		_, err := extractTrackerResponse(map[string]interface{}{"failure reason": "tracker overloaded", "retry in": 5})
+		if IsPermanent(err) {
+			t.Error("should not be permanent")
+		}
+	})
+	t.Run("Warning message", func(t *testing.T) {
+		got, err := extractTrackerResponse(map[string]interface{}{"interval": 1800, "warning message": "client outdated"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Warning != "client outdated" {
+			t.Errorf("got: %v want %v", got.Warning, "client outdated")
+		}
+	})
+}
+
+func TestAnnounceErrors(t *testing.T) {
+	newTracker := func() *HttpTracker {
+		return &HttpTracker{baseTracker{Tiers: [][]string{{"http://a1", "http://a2"}}, clock: clock.Real}}
+	}
+
+	t.Run("Tracker failure reason is preferred over connection errors", func(t *testing.T) {
+		tracker := newTracker()
+		_, err := tracker.announce(context.Background(), false, func(ctx context.Context, url string) (*TrackerResponse, error) {
+			if url == "http://a1" {
+				return nil, errors.New("connection refused")
+			}
+			return nil, &TrackerError{Reason: "client banned"}
+		})
+		if err == nil || err.Error() != "client banned" {
+			t.Errorf("got: %v want %v", err, "client banned")
+		}
+		if tracker.Status().LastError != "client banned" {
+			t.Errorf("got: %v want %v", tracker.Status().LastError, "client banned")
+		}
+	})
+
+	t.Run("Permanent failure stops retrying", func(t *testing.T) {
+		tracker := newTracker()
+		var calls int
+		_, err := tracker.announce(context.Background(), true, func(ctx context.Context, url string) (*TrackerResponse, error) {
+			calls++
+			return nil, &TrackerError{Reason: "unregistered torrent"}
+		})
+		if !IsPermanent(err) {
+			t.Errorf("got: %v want a permanent error", err)
+		}
+		if calls != 2 {
+			t.Errorf("got %v requests want 2", calls)
+		}
+	})
+
+	t.Run("Warning is kept in the status", func(t *testing.T) {
+		tracker := newTracker()
+		tracker.announce(context.Background(), false, func(ctx context.Context, url string) (*TrackerResponse, error) {
+			return &TrackerResponse{Interval: 1800, Warning: "client outdated"}, nil
+		})
+		if tracker.Status().LastWarning != "client outdated" {
+			t.Errorf("got: %v want %v", tracker.Status().LastWarning, "client outdated")
+		}
+	})
+}
+
+func TestMinInterval(t *testing.T) {
+	tracker := &HttpTracker{baseTracker{clock: clock.Real}}
+	r := TrackerResponse{Interval: 60, MinInterval: 300}
+	tracker.handleSuccessfulResponse(&r)
+	if r.Interval != 300 {
+		t.Errorf("got: %v want %v", r.Interval, 300)
+	}
+}
+
+func TestRetryMinInterval(t *testing.T) {
+	start := time.Date(2021, 5, 10, 12, 0, 0, 0, time.UTC)
+	clk := clock.NewSimulated(start)
+	tracker := &HttpTracker{baseTracker{Tiers: [][]string{{"http://a1"}}, clock: clk}}
+	tracker.handleSuccessfulResponse(&TrackerResponse{Interval: 1800, MinInterval: 300})
+
+	var calls int
+	_, err := tracker.announce(context.Background(), true, func(ctx context.Context, url string) (*TrackerResponse, error) {
+		calls++
+		if calls == 1 {
+			return nil, errors.New("connection refused")
+		}
+		return &TrackerResponse{Interval: 1800}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the 30s retry is pushed back to the min interval of the last response
+	if want := start.Add(300 * time.Second); !clk.Now().Equal(want) {
+		t.Errorf("got: %v want %v", clk.Now(), want)
+	}
+}
+
+func TestExtractTrackerId(t *testing.T) {
+	got, _ := extractTrackerResponse(map[string]interface{}{"interval": 1800, "tracker id": "abc123"})
+	if got.TrackerId != "abc123" {
+		t.Errorf("got: %v want %v", got.TrackerId, "abc123")
+	}
+}
+
+func TestAnnounceRetryCancel(t *testing.T) {
+	tracker := &HttpTracker{baseTracker{Tiers: [][]string{{"http://a1"}}, clock: clock.Real}}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := tracker.announce(ctx, true, func(ctx context.Context, url string) (*TrackerResponse, error) {
+		return nil, errors.New("connection refused")
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got: %v want %v", err, context.DeadlineExceeded)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Errorf("retry backoff should be interrupted by the context")
+	}
+}
+
+func TestAnnounceRetryBackoff(t *testing.T) {
+	start := time.Date(2021, 5, 10, 12, 0, 0, 0, time.UTC)
+	clk := clock.NewSimulated(start)
+	tracker := &HttpTracker{baseTracker{Tiers: [][]string{{"http://a1"}}, clock: clk}}
+
+	var calls int
+	_, err := tracker.announce(context.Background(), true, func(ctx context.Context, url string) (*TrackerResponse, error) {
+		calls++
+		switch {
+		case calls == 7:
+			return &TrackerResponse{Interval: 1800}, nil
+		case calls == 6:
+			return nil, &TrackerError{Reason: "tracker overloaded", RetryIn: 5}
+		case calls == 5:
+			return nil, &TrackerError{Reason: "unregistered torrent", Transient: true}
+		default:
+			return nil, errors.New("connection refused")
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 30s doubling after every connection error or transient tracker error, then the 5 minutes asked by the tracker
+	want := start.Add((30 + 60 + 120 + 240 + 480 + 300) * time.Second)
+	if !clk.Now().Equal(want) {
+		t.Errorf("got: %v want %v", clk.Now(), want)
+	}
+	if got := tracker.Status().EstimatedTimeToAnnounce; !got.Equal(want.Add(1800 * time.Second)) {
+		t.Errorf("got: %v want %v", got, want.Add(1800*time.Second))
+	}
+}
+
+func newTestRand() *rand.Rand {
+	return rand.New(rand.NewSource(1))
+}
+
+func TestMultiTrackerFallsBackToUdp(t *testing.T) {
+	dead := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer dead.Close()
+	server := newUdpTestTracker(t, defaultUdpHandler)
+
+	tracker := newMultiTracker([][]string{{dead.URL + "/announce"}, {server.url()}}, clock.Real, newTestRand())
+	resp, err := tracker.Announce(context.Background(), testAnnounceQuery("started"), nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Seeders != 42 || resp.Leechers != 7 {
+		t.Errorf("got seeders %v leechers %v want 42 7", resp.Seeders, resp.Leechers)
+	}
+	if want := BuildFullUrl(server.url(), testAnnounceQuery("started")); tracker.Status().LastAnounceRequest != want {
+		t.Errorf("got: %v want %v", tracker.Status().LastAnounceRequest, want)
+	}
+}
@@ -0,0 +1,371 @@
+package tracker
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/ap-pauloafonso/ratio-spoof/bencode"
+	"github.com/ap-pauloafonso/ratio-spoof/clock"
+	"hash/crc32"
+	"math/rand"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+)
+
+// BEP 15 constants, see http://www.bittorrent.org/beps/bep_0015.html
+const (
+	udpProtocolId = 0x41727101980
+
+	udpActionConnect  = 0
+	udpActionAnnounce = 1
+	udpActionScrape   = 2
+	udpActionError    = 3
+
+	udpEventNone      = 0
+	udpEventCompleted = 1
+	udpEventStarted   = 2
+	udpEventStopped   = 3
+
+	udpConnectionIdLifetime = time.Minute
+	udpBaseTimeout          = 15 * time.Second
+	udpMaxRetransmissions   = 8
+	udpMaxPacketSize        = 2048
+)
+
+// UdpTracker announces through the BEP 15 udp tracker protocol, it accepts the same query
+// rendered from the emulation template as the HttpTracker and translates it to the binary packet
+type UdpTracker struct {
+	baseTracker
+	connections        map[string]udpConnection
+	baseTimeout        time.Duration
+	maxRetransmissions int
+}
+
+type udpConnection struct {
+	id      uint64
+	expires time.Time
+}
+
+// udpAnnounceParams is the subset of the announce query that has a place in the udp packet
+type udpAnnounceParams struct {
+	infoHash   []byte
+	peerId     []byte
+	downloaded int64
+	left       int64
+	uploaded   int64
+	event      uint32
+	key        uint32
+	numWant    int32
+	port       uint16
+}
+
+func NewUdpTracker(torrentInfo *bencode.TorrentInfo, clk clock.Clock, rng *rand.Rand) (*UdpTracker, error) {
+	result := filterTiers(torrentInfo.TrackerInfo.Tiers, "udp")
+	if len(result) == 0 {
+		return nil, fmt.Errorf("%w (udp)", ErrNoTrackerUrl)
+	}
+	return newUdpTracker(shuffleTiers(result, rng), clk, rng), nil
+}
+
+func newUdpTracker(tiers [][]string, clk clock.Clock, rng *rand.Rand) *UdpTracker {
+	return &UdpTracker{
+		baseTracker:        baseTracker{Tiers: tiers, clock: clk, rng: rng},
+		connections:        make(map[string]udpConnection),
+		baseTimeout:        udpBaseTimeout,
+		maxRetransmissions: udpMaxRetransmissions,
+	}
+}
+
+// Announce sends the announce to the first udp url that answers, headers are ignored since the udp protocol has none
+func (t *UdpTracker) Announce(ctx context.Context, query string, headers map[string]string, retry bool) (*TrackerResponse, error) {
+	if _, err := parseUdpAnnounceQuery(query); err != nil {
+		return nil, err
+	}
+	return t.announce(ctx, retry, func(ctx context.Context, trackerUrl string) (*TrackerResponse, error) {
+		return t.announceUrl(ctx, trackerUrl, query)
+	})
+}
+
+// announceUrl translates the query to the udp packet and announces it to the url
+func (t *UdpTracker) announceUrl(ctx context.Context, trackerUrl, query string) (*TrackerResponse, error) {
+	t.LastAnounceRequest = BuildFullUrl(trackerUrl, query)
+	params, err := parseUdpAnnounceQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := t.tryAnnounce(ctx, trackerUrl, params)
+	if err != nil {
+		return nil, err
+	}
+	t.LastTackerResponse = fmt.Sprintf("interval: %v | seeders: %v | leechers: %v | peers: %v", resp.Interval, resp.Seeders, resp.Leechers, resp.Peers)
+	return resp, nil
+}
+
+// Scrape asks the first udp url that answers for the swarm statistics of the url encoded info hash, headers are ignored
+func (t *UdpTracker) Scrape(ctx context.Context, infoHash string, headers map[string]string) (*ScrapeResponse, error) {
+	rawHash, err := rawInfoHash(infoHash)
+	if err != nil {
+		return nil, err
+	}
+	for _, tier := range t.Tiers {
+		for _, trackerUrl := range tier {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			resp, err := t.tryScrape(ctx, trackerUrl, []byte(rawHash))
+			if err != nil {
+				continue
+			}
+			return resp, nil
+		}
+	}
+	return nil, ErrTrackerUnreachable
+}
+
+func (t *UdpTracker) tryAnnounce(ctx context.Context, trackerUrl string, params udpAnnounceParams) (*TrackerResponse, error) {
+	resp, remote, err := t.request(ctx, trackerUrl, udpActionAnnounce, func(connectionId uint64, transactionId uint32) []byte {
+		return buildUdpAnnouncePacket(connectionId, transactionId, params)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(resp) < 20 {
+		return nil, errors.New("udp announce response too short")
+	}
+	return &TrackerResponse{
+		Interval: int(binary.BigEndian.Uint32(resp[8:12])),
+		Leechers: int(binary.BigEndian.Uint32(resp[12:16])),
+		Seeders:  int(binary.BigEndian.Uint32(resp[16:20])),
+		Peers:    decodeCompactPeers(resp[20:], udpPeerIPLen(remote)),
+	}, nil
+}
+
+func (t *UdpTracker) tryScrape(ctx context.Context, trackerUrl string, infoHash []byte) (*ScrapeResponse, error) {
+	resp, _, err := t.request(ctx, trackerUrl, udpActionScrape, func(connectionId uint64, transactionId uint32) []byte {
+		packet := make([]byte, 16, 16+len(infoHash))
+		binary.BigEndian.PutUint64(packet[0:8], connectionId)
+		binary.BigEndian.PutUint32(packet[8:12], udpActionScrape)
+		binary.BigEndian.PutUint32(packet[12:16], transactionId)
+		return append(packet, infoHash...)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(resp) < 20 {
+		return nil, errors.New("udp scrape response too short")
+	}
+	return &ScrapeResponse{
+		Seeders:   int(binary.BigEndian.Uint32(resp[8:12])),
+		Completed: int(binary.BigEndian.Uint32(resp[12:16])),
+		Leechers:  int(binary.BigEndian.Uint32(resp[16:20])),
+	}, nil
+}
+
+// request performs an action against the tracker, connecting first when there is no valid connection id cached.
+// build is called on every retransmission so an expired connection id is renewed before resending.
+// The socket is closed when the context is done, unblocking any pending read. The tracker address the host resolved to is returned with the response
+func (t *UdpTracker) request(ctx context.Context, trackerUrl string, action uint32, build func(connectionId uint64, transactionId uint32) []byte) ([]byte, net.Addr, error) {
+	host, err := udpHost(trackerUrl)
+	if err != nil {
+		return nil, nil, err
+	}
+	conn, err := net.Dial("udp", host)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer conn.Close()
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	retried := false
+	for n := 0; n <= t.maxRetransmissions; n++ {
+		// the connect and the request share the retransmission counter, a connect that times out counts as a retransmission
+		connectionId, err := t.connectionId(conn, host, n)
+		if ctx.Err() != nil {
+			return nil, nil, ctx.Err()
+		}
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			continue
+		}
+		if err != nil {
+			delete(t.connections, host)
+			return nil, nil, err
+		}
+		transactionId := t.rng.Uint32()
+		resp, err := t.exchange(conn, build(connectionId, transactionId), action, transactionId, n)
+		if ctx.Err() != nil {
+			return nil, nil, ctx.Err()
+		}
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			continue
+		}
+		var trackerErr *TrackerError
+		if errors.As(err, &trackerErr) && !retried {
+			// the error may come from an expired connection id or a busy tracker, it is only returned when it repeats
+			// with a fresh connection id
+			retried = true
+			delete(t.connections, host)
+			n--
+			continue
+		}
+		if err != nil {
+			delete(t.connections, host)
+			return nil, nil, err
+		}
+		return resp, conn.RemoteAddr(), nil
+	}
+	delete(t.connections, host)
+	return nil, nil, errors.New("udp tracker did not respond")
+}
+
+// connectionId returns the cached connection id of the host, or sends a single connect waiting as the n-th retransmission
+func (t *UdpTracker) connectionId(conn net.Conn, host string, n int) (uint64, error) {
+	if c, ok := t.connections[host]; ok && t.clock.Now().Before(c.expires) {
+		return c.id, nil
+	}
+	transactionId := t.rng.Uint32()
+	packet := make([]byte, 16)
+	binary.BigEndian.PutUint64(packet[0:8], udpProtocolId)
+	binary.BigEndian.PutUint32(packet[8:12], udpActionConnect)
+	binary.BigEndian.PutUint32(packet[12:16], transactionId)
+	resp, err := t.exchange(conn, packet, udpActionConnect, transactionId, n)
+	if err != nil {
+		return 0, err
+	}
+	if len(resp) < 16 {
+		return 0, errors.New("udp connect response too short")
+	}
+	id := binary.BigEndian.Uint64(resp[8:16])
+	t.connections[host] = udpConnection{id: id, expires: t.clock.Now().Add(udpConnectionIdLifetime)}
+	return id, nil
+}
+
+// exchange sends the packet and waits 15 * 2 ^ n seconds for a matching response, packets from other transactions are ignored
+func (t *UdpTracker) exchange(conn net.Conn, packet []byte, action, transactionId uint32, n int) ([]byte, error) {
+	if _, err := conn.Write(packet); err != nil {
+		return nil, err
+	}
+	conn.SetReadDeadline(time.Now().Add(t.baseTimeout * time.Duration(1<<n)))
+	buf := make([]byte, udpMaxPacketSize)
+	for {
+		size, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		if size < 8 || binary.BigEndian.Uint32(buf[4:8]) != transactionId {
+			continue
+		}
+		respAction := binary.BigEndian.Uint32(buf[0:4])
+		if respAction == udpActionError {
+			// the udp error reply has no way to tell a banned torrent from a busy tracker, it is always retried
+			return nil, &TrackerError{Reason: string(buf[8:size]), Transient: true}
+		}
+		if respAction != action {
+			return nil, fmt.Errorf("unexpected udp tracker action %v", respAction)
+		}
+		return buf[:size], nil
+	}
+}
+
+func buildUdpAnnouncePacket(connectionId uint64, transactionId uint32, params udpAnnounceParams) []byte {
+	packet := make([]byte, 98)
+	binary.BigEndian.PutUint64(packet[0:8], connectionId)
+	binary.BigEndian.PutUint32(packet[8:12], udpActionAnnounce)
+	binary.BigEndian.PutUint32(packet[12:16], transactionId)
+	copy(packet[16:36], params.infoHash)
+	copy(packet[36:56], params.peerId)
+	binary.BigEndian.PutUint64(packet[56:64], uint64(params.downloaded))
+	binary.BigEndian.PutUint64(packet[64:72], uint64(params.left))
+	binary.BigEndian.PutUint64(packet[72:80], uint64(params.uploaded))
+	binary.BigEndian.PutUint32(packet[80:84], params.event)
+	// packet[84:88] ip address, 0 lets the tracker use the sender address
+	binary.BigEndian.PutUint32(packet[88:92], params.key)
+	binary.BigEndian.PutUint32(packet[92:96], uint32(params.numWant))
+	binary.BigEndian.PutUint16(packet[96:98], params.port)
+	return packet
+}
+
+func parseUdpAnnounceQuery(query string) (udpAnnounceParams, error) {
+	var result udpAnnounceParams
+	values, err := url.ParseQuery(query)
+	if err != nil {
+		return result, err
+	}
+	result.infoHash = []byte(values.Get("info_hash"))
+	if len(result.infoHash) != 20 {
+		return result, errors.New("info_hash must have 20 bytes")
+	}
+	result.peerId = []byte(values.Get("peer_id"))
+	if len(result.peerId) != 20 {
+		return result, errors.New("peer_id must have 20 bytes")
+	}
+	if result.downloaded, err = strconv.ParseInt(values.Get("downloaded"), 10, 64); err != nil {
+		return result, errors.New("invalid downloaded value")
+	}
+	if result.left, err = strconv.ParseInt(values.Get("left"), 10, 64); err != nil {
+		return result, errors.New("invalid left value")
+	}
+	if result.uploaded, err = strconv.ParseInt(values.Get("uploaded"), 10, 64); err != nil {
+		return result, errors.New("invalid uploaded value")
+	}
+	port, err := strconv.ParseUint(values.Get("port"), 10, 16)
+	if err != nil {
+		return result, errors.New("invalid port value")
+	}
+	result.port = uint16(port)
+
+	switch values.Get("event") {
+	case "started":
+		result.event = udpEventStarted
+	case "completed":
+		result.event = udpEventCompleted
+	case "stopped":
+		result.event = udpEventStopped
+	default:
+		result.event = udpEventNone
+	}
+
+	result.numWant = -1
+	if numWant, err := strconv.ParseInt(values.Get("numwant"), 10, 32); err == nil {
+		result.numWant = int32(numWant)
+	}
+
+	// clients send the key as hex in http announces, anything else is hashed to keep it stable
+	key := values.Get("key")
+	if k, err := strconv.ParseUint(key, 16, 32); err == nil {
+		result.key = uint32(k)
+	} else {
+		result.key = crc32.ChecksumIEEE([]byte(key))
+	}
+	return result, nil
+}
+
+// udpPeerIPLen returns the peer address size of the announce response, trackers reached over ipv6 answer with ipv6 peers
+func udpPeerIPLen(remote net.Addr) int {
+	if addr, ok := remote.(*net.UDPAddr); ok && addr.IP.To4() == nil {
+		return net.IPv6len
+	}
+	return net.IPv4len
+}
+
+func udpHost(trackerUrl string) (string, error) {
+	u, err := url.Parse(trackerUrl)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme != "udp" || u.Host == "" {
+		return "", fmt.Errorf("invalid udp tracker url %v", trackerUrl)
+	}
+	return u.Host, nil
+}
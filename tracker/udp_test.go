@@ -0,0 +1,436 @@
+package tracker
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ap-pauloafonso/ratio-spoof/bencode"
+	"github.com/ap-pauloafonso/ratio-spoof/clock"
+)
+
+const (
+	testInfoHashEncoded = "%01%02%03%04%05%06%07%08%09%0a%0b%0c%0d%0e%0f%10%11%12%13%14"
+	testPeerId          = "-qB4330-abcdefghijkl"
+	testConnectionId    = 0x1122334455667788
+)
+
+var testInfoHash = []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20}
+
+// udpTestTracker is a local stand-in tracker, handle returns the response for each packet or nil to drop it
+type udpTestTracker struct {
+	conn     net.PacketConn
+	mu       sync.Mutex
+	received [][]byte
+}
+
+func newUdpTestTracker(t *testing.T, handle func(packet []byte) []byte) *udpTestTracker {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &udpTestTracker{conn: conn}
+	go func() {
+		buf := make([]byte, udpMaxPacketSize)
+		for {
+			size, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			packet := append([]byte(nil), buf[:size]...)
+			server.mu.Lock()
+			server.received = append(server.received, packet)
+			resp := handle(packet)
+			server.mu.Unlock()
+			if resp != nil {
+				conn.WriteTo(resp, addr)
+			}
+		}
+	}()
+	t.Cleanup(func() { conn.Close() })
+	return server
+}
+
+func (s *udpTestTracker) packets() [][]byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([][]byte(nil), s.received...)
+}
+
+func (s *udpTestTracker) url() string {
+	return "udp://" + s.conn.LocalAddr().String() + "/announce"
+}
+
+func newTestUdpTracker(t *testing.T, urls ...string) *UdpTracker {
+	t.Helper()
+	tracker, err := NewUdpTracker(&bencode.TorrentInfo{TrackerInfo: &bencode.TrackerInfo{Tiers: [][]string{urls}}}, clock.Real, newTestRand())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracker.baseTimeout = 50 * time.Millisecond
+	tracker.maxRetransmissions = 2
+	return tracker
+}
+
+func connectResponse(packet []byte) []byte {
+	resp := make([]byte, 16)
+	binary.BigEndian.PutUint32(resp[0:4], udpActionConnect)
+	copy(resp[4:8], packet[12:16])
+	binary.BigEndian.PutUint64(resp[8:16], testConnectionId)
+	return resp
+}
+
+func announceResponse(packet []byte, interval, leechers, seeders uint32) []byte {
+	resp := make([]byte, 20)
+	binary.BigEndian.PutUint32(resp[0:4], udpActionAnnounce)
+	copy(resp[4:8], packet[12:16])
+	binary.BigEndian.PutUint32(resp[8:12], interval)
+	binary.BigEndian.PutUint32(resp[12:16], leechers)
+	binary.BigEndian.PutUint32(resp[16:20], seeders)
+	return resp
+}
+
+func defaultUdpHandler(packet []byte) []byte {
+	switch binary.BigEndian.Uint32(packet[8:12]) {
+	case udpActionConnect:
+		return connectResponse(packet)
+	case udpActionAnnounce:
+		return announceResponse(packet, 1800, 7, 42)
+	}
+	return nil
+}
+
+func testAnnounceQuery(event string) string {
+	return "info_hash=" + testInfoHashEncoded + "&peer_id=" + testPeerId + "&port=8999&uploaded=3000&downloaded=2000&left=1000&corrupt=0&key=A1B2C3D4&event=" + event + "&numwant=200&compact=1"
+}
+
+func TestNewUdpTracker(t *testing.T) {
+	_, err := NewUdpTracker(&bencode.TorrentInfo{TrackerInfo: &bencode.TrackerInfo{Tiers: [][]string{{"http://url1", "https://url2"}}}}, clock.Real, newTestRand())
+	got := err.Error()
+	want := "No tracker url announce found (udp)"
+
+	if got != want {
+		t.Errorf("got: %v want %v", got, want)
+	}
+}
+
+func TestUdpAnnounce(t *testing.T) {
+	server := newUdpTestTracker(t, defaultUdpHandler)
+	tracker := newTestUdpTracker(t, server.url())
+
+	got, err := tracker.Announce(context.Background(), testAnnounceQuery("started"), nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &TrackerResponse{Interval: 1800, Leechers: 7, Seeders: 42}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got: %v want %v", got, want)
+	}
+
+	received := server.packets()
+	if len(received) != 2 {
+		t.Fatalf("got %v packets want 2", len(received))
+	}
+
+	t.Run("Connect packet layout", func(t *testing.T) {
+		connect := received[0]
+		want := make([]byte, 16)
+		binary.BigEndian.PutUint64(want[0:8], 0x41727101980)
+		binary.BigEndian.PutUint32(want[8:12], 0)
+		copy(want[12:16], connect[12:16])
+		if !bytes.Equal(connect, want) {
+			t.Errorf("got: %x want %x", connect, want)
+		}
+	})
+
+	t.Run("Announce packet layout", func(t *testing.T) {
+		announce := received[1]
+		want := make([]byte, 98)
+		binary.BigEndian.PutUint64(want[0:8], testConnectionId)
+		binary.BigEndian.PutUint32(want[8:12], 1)
+		copy(want[12:16], announce[12:16])
+		copy(want[16:36], testInfoHash)
+		copy(want[36:56], testPeerId)
+		binary.BigEndian.PutUint64(want[56:64], 2000)
+		binary.BigEndian.PutUint64(want[64:72], 1000)
+		binary.BigEndian.PutUint64(want[72:80], 3000)
+		binary.BigEndian.PutUint32(want[80:84], 2)
+		binary.BigEndian.PutUint32(want[88:92], 0xA1B2C3D4)
+		binary.BigEndian.PutUint32(want[92:96], 200)
+		binary.BigEndian.PutUint16(want[96:98], 8999)
+		if !bytes.Equal(announce, want) {
+			t.Errorf("\ngot : %x\nwant: %x", announce, want)
+		}
+	})
+}
+
+func TestUdpAnnouncePeers(t *testing.T) {
+	server := newUdpTestTracker(t, func(packet []byte) []byte {
+		if binary.BigEndian.Uint32(packet[8:12]) == udpActionAnnounce {
+			return append(announceResponse(packet, 1800, 1, 1), 10, 0, 0, 1, 0x1a, 0xe1, 192, 168, 1, 2, 0, 80)
+		}
+		return defaultUdpHandler(packet)
+	})
+	tracker := newTestUdpTracker(t, server.url())
+
+	got, err := tracker.Announce(context.Background(), testAnnounceQuery("started"), nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Peer{{IP: net.IP{10, 0, 0, 1}, Port: 6881}, {IP: net.IP{192, 168, 1, 2}, Port: 80}}
+	if !reflect.DeepEqual(got.Peers, want) {
+		t.Errorf("got: %v want %v", got.Peers, want)
+	}
+}
+
+func TestUdpConnectionIdCache(t *testing.T) {
+	server := newUdpTestTracker(t, defaultUdpHandler)
+	tracker := newTestUdpTracker(t, server.url())
+
+	for _, event := range []string{"started", "", "stopped"} {
+		if _, err := tracker.Announce(context.Background(), testAnnounceQuery(event), nil, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var connects int
+	for _, packet := range server.packets() {
+		if binary.BigEndian.Uint32(packet[8:12]) == udpActionConnect {
+			connects++
+		}
+	}
+	if connects != 1 {
+		t.Errorf("got %v connect requests want 1", connects)
+	}
+
+	t.Run("Expired connection id should connect again", func(t *testing.T) {
+		host, _ := udpHost(server.url())
+		tracker.connections[host] = udpConnection{id: testConnectionId, expires: time.Now().Add(-time.Second)}
+		before := len(server.packets())
+		if _, err := tracker.Announce(context.Background(), testAnnounceQuery(""), nil, false); err != nil {
+			t.Fatal(err)
+		}
+		if got := len(server.packets()) - before; got != 2 {
+			t.Errorf("got %v packets want 2", got)
+		}
+	})
+}
+
+func TestUdpRetransmission(t *testing.T) {
+	var announces int
+	server := newUdpTestTracker(t, func(packet []byte) []byte {
+		if binary.BigEndian.Uint32(packet[8:12]) == udpActionAnnounce {
+			announces++
+			if announces == 1 {
+				return nil
+			}
+		}
+		return defaultUdpHandler(packet)
+	})
+	tracker := newTestUdpTracker(t, server.url())
+
+	got, err := tracker.Announce(context.Background(), testAnnounceQuery("started"), nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Seeders != 42 {
+		t.Errorf("got: %v want %v", got.Seeders, 42)
+	}
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if announces != 2 {
+		t.Errorf("got %v announce packets want 2", announces)
+	}
+}
+
+func TestUdpRetransmissionLimit(t *testing.T) {
+	var connects int
+	server := newUdpTestTracker(t, func(packet []byte) []byte {
+		if binary.BigEndian.Uint32(packet[8:12]) == udpActionConnect {
+			connects++
+			if connects > 2 {
+				return connectResponse(packet)
+			}
+		}
+		return nil
+	})
+	tracker := newTestUdpTracker(t, server.url())
+
+	if _, err := tracker.Announce(context.Background(), testAnnounceQuery("started"), nil, false); err == nil {
+		t.Fatal("should return error")
+	}
+	// the two lost connects use up two of the three transmissions, the announce is only sent once
+	if got := len(server.packets()); got != 4 {
+		t.Errorf("got %v packets want %v", got, 4)
+	}
+}
+
+func TestUdpFallbackToNextUrl(t *testing.T) {
+	silent := newUdpTestTracker(t, func(packet []byte) []byte { return nil })
+	server := newUdpTestTracker(t, defaultUdpHandler)
+	tracker := newTestUdpTracker(t, silent.url(), server.url())
+
+	if _, err := tracker.Announce(context.Background(), testAnnounceQuery("started"), nil, false); err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{{server.url(), silent.url()}}
+	if !reflect.DeepEqual(tracker.Tiers, want) {
+		t.Errorf("got: %v want %v", tracker.Tiers, want)
+	}
+}
+
+func TestUdpAnnounceCancel(t *testing.T) {
+	silent := newUdpTestTracker(t, func(packet []byte) []byte { return nil })
+	tracker := newTestUdpTracker(t, silent.url())
+	tracker.baseTimeout = time.Minute
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := tracker.Announce(ctx, testAnnounceQuery("started"), nil, false)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got: %v want %v", err, context.DeadlineExceeded)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Errorf("pending udp read should be interrupted by the context")
+	}
+}
+
+func TestUdpErrorAction(t *testing.T) {
+	server := newUdpTestTracker(t, func(packet []byte) []byte {
+		if binary.BigEndian.Uint32(packet[8:12]) == udpActionConnect {
+			return connectResponse(packet)
+		}
+		resp := make([]byte, 8)
+		binary.BigEndian.PutUint32(resp[0:4], udpActionError)
+		copy(resp[4:8], packet[12:16])
+		return append(resp, "unregistered torrent"...)
+	})
+	tracker := newTestUdpTracker(t, server.url())
+	host, _ := udpHost(server.url())
+
+	_, err := tracker.tryAnnounce(context.Background(), server.url(), mustParseUdpQuery(t, testAnnounceQuery("started")))
+	if err == nil || err.Error() != "unregistered torrent" {
+		t.Errorf("got: %v want %v", err, "unregistered torrent")
+	}
+	if IsPermanent(err) {
+		t.Error("udp error reply should be retried")
+	}
+	if _, ok := tracker.connections[host]; ok {
+		t.Error("connection id should be discarded after an error")
+	}
+}
+
+func TestUdpErrorActionRetried(t *testing.T) {
+	var announces int
+	server := newUdpTestTracker(t, func(packet []byte) []byte {
+		if binary.BigEndian.Uint32(packet[8:12]) == udpActionConnect {
+			return connectResponse(packet)
+		}
+		announces++
+		if announces == 1 {
+			resp := make([]byte, 8)
+			binary.BigEndian.PutUint32(resp[0:4], udpActionError)
+			copy(resp[4:8], packet[12:16])
+			return append(resp, "connection id expired"...)
+		}
+		return announceResponse(packet, 1800, 7, 42)
+	})
+	tracker := newTestUdpTracker(t, server.url())
+
+	resp, err := tracker.tryAnnounce(context.Background(), server.url(), mustParseUdpQuery(t, testAnnounceQuery("started")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Seeders != 42 {
+		t.Errorf("got: %v want %v", resp.Seeders, 42)
+	}
+	var connects int
+	for _, packet := range server.packets() {
+		if binary.BigEndian.Uint32(packet[8:12]) == udpActionConnect {
+			connects++
+		}
+	}
+	if connects != 2 {
+		t.Errorf("got %v connects want 2, the error should renew the connection id", connects)
+	}
+}
+
+func TestUdpScrape(t *testing.T) {
+	server := newUdpTestTracker(t, func(packet []byte) []byte {
+		if binary.BigEndian.Uint32(packet[8:12]) == udpActionConnect {
+			return connectResponse(packet)
+		}
+		if len(packet) != 36 || !bytes.Equal(packet[16:36], testInfoHash) {
+			return nil
+		}
+		resp := make([]byte, 20)
+		binary.BigEndian.PutUint32(resp[0:4], udpActionScrape)
+		copy(resp[4:8], packet[12:16])
+		binary.BigEndian.PutUint32(resp[8:12], 42)
+		binary.BigEndian.PutUint32(resp[12:16], 100)
+		binary.BigEndian.PutUint32(resp[16:20], 7)
+		return resp
+	})
+	tracker := newTestUdpTracker(t, server.url())
+
+	got, err := tracker.Scrape(context.Background(), testInfoHashEncoded, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &ScrapeResponse{Seeders: 42, Completed: 100, Leechers: 7}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got: %v want %v", got, want)
+	}
+}
+
+func TestUdpPeerIPLen(t *testing.T) {
+	data := []struct {
+		name     string
+		remote   net.Addr
+		expected int
+	}{
+		{name: "ipv4", remote: &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 6969}, expected: net.IPv4len},
+		{name: "ipv6", remote: &net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 6969}, expected: net.IPv6len},
+		{name: "ipv4 mapped", remote: &net.UDPAddr{IP: net.ParseIP("::ffff:10.0.0.1"), Port: 6969}, expected: net.IPv4len},
+	}
+	for _, td := range data {
+		t.Run(td.name, func(t *testing.T) {
+			if got := udpPeerIPLen(td.remote); got != td.expected {
+				t.Errorf("got: %v want %v", got, td.expected)
+			}
+		})
+	}
+}
+
+func TestParseUdpAnnounceQuery(t *testing.T) {
+	t.Run("Events", func(t *testing.T) {
+		for event, want := range map[string]uint32{"started": 2, "completed": 1, "stopped": 3, "": 0} {
+			got := mustParseUdpQuery(t, testAnnounceQuery(event)).event
+			if got != want {
+				t.Errorf("[%v]got: %v want %v", event, got, want)
+			}
+		}
+	})
+	t.Run("Invalid info hash", func(t *testing.T) {
+		_, err := parseUdpAnnounceQuery("info_hash=abc&peer_id=" + testPeerId)
+		if err == nil {
+			t.Error("should return error")
+		}
+	})
+}
+
+func mustParseUdpQuery(t *testing.T, query string) udpAnnounceParams {
+	t.Helper()
+	params, err := parseUdpAnnounceQuery(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return params
+}